@@ -0,0 +1,157 @@
+// Command spiffe-rotate issues certs from Vault/OpenBao and writes them to
+// files, for services that cannot embed the certmanager package. With -once
+// it issues a single bundle and exits; otherwise it keeps rotating until
+// interrupted, rewriting the files after every rotation.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+	"github.com/cmmoran/spiffe-rotate/pki/vault"
+)
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if err := run(os.Args[1:], os.Stdout, logger); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		logger.Error("spiffe-rotate failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// stringList is a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// run parses args, then issues once or rotates until interrupted.
+// -show-request writes the planned request to stdout instead.
+func run(args []string, stdout io.Writer, logger *slog.Logger) error {
+	fs := flag.NewFlagSet("spiffe-rotate", flag.ContinueOnError)
+	var (
+		client  vault.Client
+		issuer  vault.Issuer
+		store   certmanager.FileStore
+		uriSANs stringList
+		alts    stringList
+		keyFmt  string
+		retries int
+		once    bool
+		showReq bool
+	)
+	fs.StringVar(&client.Addr, "vault-addr", os.Getenv("VAULT_ADDR"), "Vault/OpenBao address (env VAULT_ADDR)")
+	fs.StringVar(&client.Namespace, "vault-namespace", os.Getenv("VAULT_NAMESPACE"), "Vault namespace (env VAULT_NAMESPACE)")
+	fs.StringVar(&client.Token, "vault-token", "", "Vault token; defaults to -vault-token-file, env VAULT_TOKEN, then ~/.vault-token")
+	fs.StringVar(&client.TokenFile, "vault-token-file", "", "file holding a Vault token, e.g. an agent sink; re-read when the token is rejected")
+	fs.StringVar(&client.RoleID, "role-id", os.Getenv("VAULT_ROLE_ID"), "AppRole role ID (env VAULT_ROLE_ID)")
+	fs.StringVar(&client.SecretID, "secret-id", os.Getenv("VAULT_SECRET_ID"), "AppRole secret ID (env VAULT_SECRET_ID)")
+	fs.StringVar(&client.RoleIDFile, "role-id-file", "", "file holding the AppRole role ID, re-read on every login")
+	fs.StringVar(&client.SecretIDFile, "secret-id-file", "", "file holding the AppRole secret ID, re-read on every login")
+	fs.BoolVar(&client.SecretIDWrapped, "secret-id-wrapped", false, "the secret ID is a response-wrapping token to unwrap before login")
+	fs.StringVar(&client.AuthPath, "auth-path", "", "AppRole login path, e.g. auth/approle-prod/login (default auth/approle/login)")
+	fs.StringVar(&issuer.PKIPath, "pki-path", "pki", "PKI secrets engine mount path")
+	fs.StringVar(&issuer.Role, "role", "", "PKI role to issue from")
+	fs.StringVar(&issuer.CommonName, "common-name", "", "certificate common name")
+	fs.Var(&uriSANs, "uri-san", "URI SAN, e.g. a SPIFFE ID (repeatable)")
+	fs.Var(&alts, "alt-name", "DNS SAN (repeatable)")
+	fs.DurationVar(&issuer.TTL, "ttl", 0, "requested certificate TTL (default: role TTL)")
+	fs.DurationVar(&issuer.WrapTTL, "wrap-ttl", 0, "have Vault response-wrap the issued cert and key for this long (0 disables)")
+	fs.StringVar(&store.CertFile, "cert", "tls.crt", "file to write the certificate chain to")
+	fs.StringVar(&store.KeyFile, "key", "tls.key", "file to write the private key to")
+	fs.StringVar(&keyFmt, "key-format", "pkcs8", "private key encoding: pkcs8, pkcs1 (RSA) or sec1 (ECDSA)")
+	fs.StringVar(&store.CAFile, "ca", "", "file to write the CA certs to (optional)")
+	fs.IntVar(&retries, "start-retries", 5, "retry the initial issuance this many times, 1s apart, while Vault is unreachable")
+	fs.BoolVar(&showReq, "show-request", false, "print the issue request that would be sent to Vault and exit")
+	fs.BoolVar(&once, "once", false, "issue a single bundle and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !showReq && (client.Addr == "" || issuer.Role == "") {
+		return errors.New("-vault-addr and -role are required")
+	}
+	switch keyFmt {
+	case "pkcs8":
+		store.KeyFormat = certmanager.KeyPKCS8
+	case "pkcs1":
+		store.KeyFormat = certmanager.KeyPKCS1
+	case "sec1":
+		store.KeyFormat = certmanager.KeySEC1
+	default:
+		return fmt.Errorf("unknown -key-format %q", keyFmt)
+	}
+	issuer.Client = &client
+	issuer.URISANs = uriSANs
+	issuer.AltNames = alts
+	if showReq {
+		req, err := issuer.PlannedRequest(context.Background())
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(req)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mgr := certmanager.NewWithOptions(&issuer, certmanager.Options{
+		Logger:       logger,
+		HookTimeout:  10 * time.Second,
+		StartRetries: retries,
+		OnRotate: func(ctx context.Context, info certmanager.BundleInfo) {
+			b, err := certmanager.FromContext(ctx).Current()
+			if err == nil {
+				err = store.Write(b)
+			}
+			if err != nil {
+				logger.ErrorContext(ctx, "write rotated bundle", "error", err, "rotation_id", info.RotationID)
+				return
+			}
+			logger.InfoContext(ctx, "rotated", "serial", info.SerialNumber, "not_after", info.NotAfter, "rotation_id", info.RotationID)
+		},
+		OnError: func(ctx context.Context, err error) {
+			logger.ErrorContext(ctx, "rotation failed", "error", err, "rotation_id", certmanager.RotationID(ctx))
+		},
+	})
+
+	info, err := mgr.StartInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("initial issuance: %w", err)
+	}
+	b, err := mgr.Current()
+	if err != nil {
+		return err
+	}
+	if err := store.Write(b); err != nil {
+		return err
+	}
+	logger.Info("issued", "serial", info.SerialNumber, "not_after", info.NotAfter, "cert", store.CertFile)
+	if once {
+		return nil
+	}
+
+	mgr.Run(ctx)
+	return nil
+}
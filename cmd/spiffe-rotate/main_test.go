@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var discard = slog.New(slog.DiscardHandler)
+
+func TestRunValidatesFlags(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"missing addr", []string{"-role", "svc"}, "-vault-addr and -role are required"},
+		{"missing role", []string{"-vault-addr", "http://vault:8200"}, "-vault-addr and -role are required"},
+		{"bad key format", []string{"-vault-addr", "http://vault:8200", "-role", "svc", "-key-format", "der"}, `unknown -key-format "der"`},
+		{"unknown flag", []string{"-bogus"}, "flag provided but not defined"},
+	} {
+		err := run(tc.args, &bytes.Buffer{}, discard)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: run() = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+	if err := run([]string{"-h"}, &bytes.Buffer{}, discard); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("run(-h) = %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestRunShowRequest(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	err := run([]string{
+		"-show-request",
+		"-common-name", "api",
+		"-uri-san", "spiffe://corp/prod/api",
+		"-alt-name", "api.internal",
+		"-alt-name", "api.internal",
+		"-ttl", "1h",
+	}, &out, discard)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var req map[string]any
+	if err := json.Unmarshal(out.Bytes(), &req); err != nil {
+		t.Fatalf("decode request %q: %v", out.String(), err)
+	}
+	if req["common_name"] != "api" || req["ttl"] != "1h0m0s" {
+		t.Fatalf("request = %v, want common_name and ttl", req)
+	}
+	if alts, _ := req["alt_names"].([]any); len(alts) != 1 {
+		t.Fatalf("alt_names = %v, want the duplicate dropped", req["alt_names"])
+	}
+
+	err = run([]string{"-show-request", "-uri-san", "spiffe://corp/../api"}, &out, discard)
+	if err == nil {
+		t.Fatal("expected an invalid SPIFFE ID to be rejected")
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	t.Parallel()
+
+	caPEM, leafPEM, keyPEM := newTestCerts(t)
+	var got map[string]any
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/pki/issue/svc" || r.Header.Get("X-Vault-Token") != "tok" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"certificate":      string(leafPEM),
+			"private_key":      string(keyPEM),
+			"private_key_type": "ec",
+			"issuing_ca":       string(caPEM),
+		}})
+	}))
+	t.Cleanup(vault.Close)
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	err := run([]string{
+		"-once",
+		"-vault-addr", vault.URL,
+		"-vault-token", "tok",
+		"-role", "svc",
+		"-uri-san", "spiffe://corp/prod/api",
+		"-cert", certFile,
+		"-key", keyFile,
+		"-ca", caFile,
+		"-key-format", "sec1",
+	}, &bytes.Buffer{}, discard)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if uris, _ := got["uri_sans"].([]any); len(uris) != 1 || uris[0] != "spiffe://corp/prod/api" {
+		t.Fatalf("uri_sans = %v, want the requested SPIFFE ID", got["uri_sans"])
+	}
+
+	cert, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("read cert: %v", err)
+	}
+	if !bytes.HasPrefix(cert, leafPEM) {
+		t.Fatal("expected the cert file to start with the issued leaf")
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("read key: %v", err)
+	}
+	if block, _ := pem.Decode(key); block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Fatal("expected a SEC 1 key file")
+	}
+	if ca, err := os.ReadFile(caFile); err != nil || !bytes.Contains(ca, bytes.TrimSpace(caPEM)) {
+		t.Fatalf("expected the CA file to hold the issuing CA: %v", err)
+	}
+}
+
+// newTestCerts returns a CA and an ECDSA leaf it signed, with the leaf key.
+func newTestCerts(t *testing.T) (caPEM, leafPEM, keyPEM []byte) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate leaf key: %v", err)
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		URIs:         []*url.URL{{Scheme: "spiffe", Host: "corp", Path: "/prod/api"}},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, caTmpl, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create leaf: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(leafKey)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,66 @@
+// Package certmanagertest provides helpers for testing code that embeds a
+// certmanager.Manager.
+package certmanagertest
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+)
+
+// VerifyBundle checks that the chain b presents (Cert.Certificate) verifies
+// against b's trust. When b.CACerts is populated, only its self-signed roots
+// are trusted, so intermediates must be presented alongside the leaf; this
+// catches the "only leaf presented" class of bugs.
+func VerifyBundle(b *certmanager.Bundle) error {
+	if b == nil || b.Cert == nil || len(b.Cert.Certificate) == 0 {
+		return errors.New("bundle has no certificate")
+	}
+	leaf, err := x509.ParseCertificate(b.Cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse leaf: %w", err)
+	}
+	intermediates := x509.NewCertPool()
+	for i, der := range b.Cert.Certificate[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("parse presented cert %d: %w", i+1, err)
+		}
+		intermediates.AddCert(cert)
+	}
+
+	roots := rootPool(b)
+	if roots == nil {
+		return errors.New("bundle has no CA pool")
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("presented chain does not verify: %w", err)
+	}
+	return nil
+}
+
+// rootPool returns the self-signed certs from b.CACerts, falling back to b.CA
+// when no CA certs are listed or none are self-signed.
+func rootPool(b *certmanager.Bundle) *x509.CertPool {
+	var pool *x509.CertPool
+	for _, cert := range b.CACerts {
+		if cert == nil || !bytes.Equal(cert.RawSubject, cert.RawIssuer) || cert.CheckSignatureFrom(cert) != nil {
+			continue
+		}
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AddCert(cert)
+	}
+	if pool == nil {
+		return b.CA
+	}
+	return pool
+}
@@ -0,0 +1,85 @@
+package certmanagertest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+)
+
+func TestVerifyBundle(t *testing.T) {
+	t.Parallel()
+
+	root, rootKey := newCert(t, 1, "Test Root", nil, nil, true)
+	inter, interKey := newCert(t, 2, "Test Intermediate", root, rootKey, true)
+	leaf, leafKey := newCert(t, 3, "Test Leaf", inter, interKey, false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	pool.AddCert(inter)
+	newBundle := func(chain ...*x509.Certificate) *certmanager.Bundle {
+		der := make([][]byte, 0, len(chain))
+		for _, c := range chain {
+			der = append(der, c.Raw)
+		}
+		return &certmanager.Bundle{
+			Cert:     &tls.Certificate{Certificate: der, PrivateKey: leafKey},
+			CA:       pool,
+			CACerts:  []*x509.Certificate{inter, root},
+			NotAfter: leaf.NotAfter,
+		}
+	}
+
+	if err := VerifyBundle(newBundle(leaf, inter)); err != nil {
+		t.Fatalf("expected full chain to verify: %v", err)
+	}
+	if err := VerifyBundle(newBundle(leaf)); err == nil {
+		t.Fatal("expected leaf-only chain to fail verification")
+	}
+	if err := VerifyBundle(&certmanager.Bundle{}); err == nil {
+		t.Fatal("expected empty bundle to fail verification")
+	}
+}
+
+func newCert(t *testing.T, serial int64, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse cert: %v", err)
+	}
+	return cert, key
+}
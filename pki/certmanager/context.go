@@ -0,0 +1,42 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+type (
+	rotationIDKey  struct{}
+	managerKey     struct{}
+	recoveryTTLKey struct{}
+)
+
+// FromContext returns the Manager that invoked the hook receiving ctx, or nil.
+func FromContext(ctx context.Context) *Manager {
+	m, _ := ctx.Value(managerKey{}).(*Manager)
+	return m
+}
+
+// RotationID returns the ID of the refresh attempt carried by ctx, or "".
+// Hook contexts and the issuer context carry the ID of the attempt that
+// triggered them, so one rotation can be traced across logs and hooks.
+func RotationID(ctx context.Context) string {
+	id, _ := ctx.Value(rotationIDKey{}).(string)
+	return id
+}
+
+func withRotationID(ctx context.Context) context.Context {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return context.WithValue(ctx, rotationIDKey{}, hex.EncodeToString(b[:]))
+}
+
+// RecoveryTTL returns the shortened TTL the issuer should request, or 0. The
+// issuer context carries Options.RecoveryTTL on the first issuance after one
+// or more failed attempts.
+func RecoveryTTL(ctx context.Context) time.Duration {
+	ttl, _ := ctx.Value(recoveryTTLKey{}).(time.Duration)
+	return ttl
+}
@@ -0,0 +1,149 @@
+package certmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type idIssuer struct {
+	ids chan string
+	err error
+}
+
+func (i idIssuer) Issue(ctx context.Context) (*Bundle, error) {
+	i.ids <- RotationID(ctx)
+	if i.err != nil {
+		return nil, i.err
+	}
+	return &Bundle{NotAfter: time.Now().Add(time.Hour)}, nil
+}
+
+func TestRotationIDThreadedThroughHooks(t *testing.T) {
+	t.Parallel()
+
+	issued := make(chan string, 1)
+	rotated := make(chan [2]string, 1)
+	mgr := NewWithOptions(idIssuer{ids: issued}, Options{
+		OnRotate: func(ctx context.Context, info BundleInfo) {
+			rotated <- [2]string{RotationID(ctx), info.RotationID}
+		},
+	})
+	if _, err := mgr.tick(context.Background()); err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+
+	issuerID := <-issued
+	if issuerID == "" {
+		t.Fatal("expected issuer context to carry a rotation ID")
+	}
+	select {
+	case got := <-rotated:
+		if got[0] != issuerID || got[1] != issuerID {
+			t.Fatalf("OnRotate IDs = %v, want %q", got, issuerID)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("OnRotate was not invoked")
+	}
+}
+
+func TestRotationIDOnError(t *testing.T) {
+	t.Parallel()
+
+	issued := make(chan string, 2)
+	failed := make(chan string, 2)
+	mgr := NewWithOptions(idIssuer{ids: issued, err: errors.New("down")}, Options{
+		OnError: func(ctx context.Context, _ error) {
+			failed <- RotationID(ctx)
+		},
+	})
+	for i := 0; i < 2; i++ {
+		_, _ = mgr.tick(context.Background())
+	}
+
+	first, second := <-issued, <-issued
+	if first == second {
+		t.Fatal("expected each refresh attempt to get a distinct rotation ID")
+	}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-failed:
+			got[id] = true
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("OnError was not invoked")
+		}
+	}
+	if !got[first] || !got[second] {
+		t.Fatalf("OnError IDs = %v, want %q and %q", got, first, second)
+	}
+}
+
+func TestFromContextInHooks(t *testing.T) {
+	t.Parallel()
+
+	got := make(chan *Manager, 1)
+	mgr := NewWithOptions(staticIssuer{bundle: &Bundle{NotAfter: time.Now().Add(time.Hour)}}, Options{
+		OnRotate: func(ctx context.Context, _ BundleInfo) {
+			got <- FromContext(ctx)
+		},
+	})
+	if _, err := mgr.tick(context.Background()); err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+	select {
+	case m := <-got:
+		if m != mgr {
+			t.Fatal("expected FromContext to return the invoking manager")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("OnRotate was not invoked")
+	}
+
+	if FromContext(context.Background()) != nil {
+		t.Fatal("expected nil manager for a plain context")
+	}
+}
+
+// ttlIssuer records RecoveryTTL(ctx) per call and fails while err is set.
+type ttlIssuer struct {
+	ttls []time.Duration
+	err  error
+}
+
+func (i *ttlIssuer) Issue(ctx context.Context) (*Bundle, error) {
+	i.ttls = append(i.ttls, RecoveryTTL(ctx))
+	if i.err != nil {
+		return nil, i.err
+	}
+	return &Bundle{NotAfter: time.Now().Add(time.Hour)}, nil
+}
+
+func TestRecoveryTTLOfferedAfterFailures(t *testing.T) {
+	t.Parallel()
+
+	issuer := &ttlIssuer{err: errors.New("down")}
+	mgr := NewWithOptions(issuer, Options{RecoveryTTL: 10 * time.Minute})
+	ctx := context.Background()
+
+	_ = mgr.Rotate(ctx)
+	_ = mgr.Rotate(ctx)
+	issuer.err = nil
+	if err := mgr.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if err := mgr.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	want := []time.Duration{0, 10 * time.Minute, 10 * time.Minute, 0}
+	if len(issuer.ttls) != len(want) {
+		t.Fatalf("ttls = %v, want %v", issuer.ttls, want)
+	}
+	for i := range want {
+		if issuer.ttls[i] != want[i] {
+			t.Fatalf("ttls = %v, want %v", issuer.ttls, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+)
+
+// DualManager serves two independently rotating bundles, typically an ECDSA
+// and an RSA cert issued from separate roles, and picks per handshake based on
+// what the peer supports. ECDSA is preferred when both are acceptable.
+type DualManager struct {
+	ECDSA *Manager
+	RSA   *Manager
+}
+
+// Start fetches the initial bundle for both managers.
+func (d *DualManager) Start(ctx context.Context) error {
+	return errors.Join(d.ECDSA.Start(ctx), d.RSA.Start(ctx))
+}
+
+// Run runs both managers' rotation schedules until ctx is canceled.
+func (d *DualManager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, m := range []*Manager{d.ECDSA, d.RSA} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// GetCertificate is a tls.Config GetCertificate callback. A nil hello, e.g.
+// from a direct call, gets the ECDSA cert if it is ready.
+func (d *DualManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello == nil {
+		return d.pick(nil)
+	}
+	return d.pick(func(c *tls.Certificate) error {
+		return hello.SupportsCertificate(c)
+	})
+}
+
+// GetClientCertificate is a tls.Config GetClientCertificate callback.
+func (d *DualManager) GetClientCertificate(req *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if req == nil {
+		return d.pick(nil)
+	}
+	return d.pick(func(c *tls.Certificate) error {
+		return req.SupportsCertificate(c)
+	})
+}
+
+// pick returns the first ready cert the peer supports, falling back to any
+// ready cert so the handshake can surface a meaningful error. A nil supports
+// accepts any cert.
+func (d *DualManager) pick(supports func(*tls.Certificate) error) (*tls.Certificate, error) {
+	var fallback *tls.Certificate
+	for _, m := range []*Manager{d.ECDSA, d.RSA} {
+		b, err := m.Current()
+		if err != nil {
+			continue
+		}
+		if supports == nil || supports(b.Cert) == nil {
+			return b.Cert, nil
+		}
+		if fallback == nil {
+			fallback = b.Cert
+		}
+	}
+	if fallback == nil {
+		return nil, ErrNotReady
+	}
+	return fallback, nil
+}
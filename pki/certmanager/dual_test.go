@@ -0,0 +1,75 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"testing"
+)
+
+func TestDualManagerSelectsByClientSupport(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	ecBundle := ca.bundle(t, 1, testSpiffeID)
+	rsaBundle := ca.bundleWithKey(t, 2, testSpiffeID, rsaKey)
+
+	dual := &DualManager{
+		ECDSA: New(staticIssuer{bundle: ecBundle}),
+		RSA:   New(staticIssuer{bundle: rsaBundle}),
+	}
+	if err := dual.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	ecHello := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+	}
+	got, err := dual.GetCertificate(ecHello)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if got != ecBundle.Cert {
+		t.Fatal("expected ECDSA cert for a client supporting ECDSA")
+	}
+
+	rsaHello := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+	}
+	got, err = dual.GetCertificate(rsaHello)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if got != rsaBundle.Cert {
+		t.Fatal("expected RSA cert for an RSA-only client")
+	}
+
+	if got, err := dual.GetCertificate(nil); err != nil || got != ecBundle.Cert {
+		t.Fatalf("GetCertificate(nil) = %v, %v; want the ECDSA cert", got, err)
+	}
+	if got, err := dual.GetClientCertificate(nil); err != nil || got != ecBundle.Cert {
+		t.Fatalf("GetClientCertificate(nil) = %v, %v; want the ECDSA cert", got, err)
+	}
+}
+
+func TestDualManagerNotReady(t *testing.T) {
+	t.Parallel()
+
+	dual := &DualManager{
+		ECDSA: New(staticIssuer{}),
+		RSA:   New(staticIssuer{}),
+	}
+	if _, err := dual.GetCertificate(&tls.ClientHelloInfo{}); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("GetCertificate() = %v, want ErrNotReady", err)
+	}
+}
@@ -0,0 +1,37 @@
+package certmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// FailoverIssuer tries Primary first on every issuance and falls back to
+// Secondary on error. The returned bundle, including its CA pool, comes from
+// whichever issuer succeeded, so the two may use independent trust chains.
+type FailoverIssuer struct {
+	Primary   Issuer
+	Secondary Issuer
+}
+
+func (f FailoverIssuer) Issue(ctx context.Context) (*Bundle, error) {
+	if f.Primary == nil {
+		return nil, errors.New("failover primary issuer required")
+	}
+	bundle, err := f.Primary.Issue(ctx)
+	if err == nil && bundle != nil {
+		return bundle, nil
+	}
+	if err == nil {
+		err = ErrNilBundle
+	}
+	if f.Secondary == nil || ctx.Err() != nil {
+		return nil, err
+	}
+
+	bundle, err2 := f.Secondary.Issue(ctx)
+	if err2 != nil {
+		return nil, errors.Join(fmt.Errorf("primary: %w", err), fmt.Errorf("secondary: %w", err2))
+	}
+	return bundle, nil
+}
@@ -0,0 +1,52 @@
+package certmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailoverIssuerPrefersPrimary(t *testing.T) {
+	t.Parallel()
+
+	primaryBundle := &Bundle{NotAfter: time.Now().Add(time.Hour)}
+	secondaryBundle := &Bundle{NotAfter: time.Now().Add(2 * time.Hour)}
+	primary := &flakyIssuer{bundle: primaryBundle, err: errors.New("primary down")}
+	issuer := FailoverIssuer{
+		Primary:   primary,
+		Secondary: &flakyIssuer{bundle: secondaryBundle},
+	}
+
+	got, err := issuer.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got != secondaryBundle {
+		t.Fatal("expected secondary bundle while primary is down")
+	}
+
+	primary.err = nil
+	got, err = issuer.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got != primaryBundle {
+		t.Fatal("expected primary bundle once primary recovers")
+	}
+}
+
+func TestFailoverIssuerBothFail(t *testing.T) {
+	t.Parallel()
+
+	errPrimary := errors.New("primary down")
+	errSecondary := errors.New("secondary down")
+	issuer := FailoverIssuer{
+		Primary:   &flakyIssuer{err: errPrimary},
+		Secondary: &flakyIssuer{err: errSecondary},
+	}
+	_, err := issuer.Issue(context.Background())
+	if !errors.Is(err, errPrimary) || !errors.Is(err, errSecondary) {
+		t.Fatalf("Issue() = %v, want both errors", err)
+	}
+}
@@ -0,0 +1,89 @@
+package certmanager
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileStore writes bundles to PEM files for consumers that cannot call the
+// Manager directly, e.g. a sidecar feeding a non-Go service. Each file is
+// replaced atomically via a temp file and rename. Empty paths are skipped.
+type FileStore struct {
+	// CertFile receives the presented chain (leaf first).
+	CertFile string
+	// KeyFile receives the private key, by default as PKCS#8; it is written
+	// with mode 0600.
+	KeyFile string
+	// KeyFormat selects the key encoding for tools that expect e.g. an
+	// "RSA PRIVATE KEY" or "EC PRIVATE KEY" block.
+	KeyFormat KeyFormat
+	// CAFile receives Bundle.CACerts.
+	CAFile string
+}
+
+// Write stores b. Keys that cannot be exported (e.g. HSM-backed signers)
+// fail when KeyFile is set.
+func (s FileStore) Write(b *Bundle) error {
+	if b == nil || b.Cert == nil || len(b.Cert.Certificate) == 0 {
+		return ErrNilBundle
+	}
+	if s.CertFile != "" {
+		var buf bytes.Buffer
+		for _, der := range b.Cert.Certificate {
+			_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		}
+		if err := writeFileAtomic(s.CertFile, buf.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("write cert: %w", err)
+		}
+	}
+	if s.KeyFile != "" {
+		data, err := MarshalPrivateKeyPEM(b.Cert.PrivateKey, s.KeyFormat)
+		if err != nil {
+			return fmt.Errorf("write key: %w", err)
+		}
+		if err := writeFileAtomic(s.KeyFile, data, 0o600); err != nil {
+			return fmt.Errorf("write key: %w", err)
+		}
+	}
+	if s.CAFile != "" {
+		if len(b.CACerts) == 0 {
+			return errors.New("write CA: bundle has no CA certs")
+		}
+		var buf bytes.Buffer
+		for _, cert := range b.CACerts {
+			_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		if err := writeFileAtomic(s.CAFile, buf.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("write CA: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file beside name and renames it into
+// place, so readers never observe a partial file.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,67 @@
+package certmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreWritesBundle(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := FileStore{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	ca := newTestCA(t)
+	bundle := ca.bundle(t, 1, testSpiffeID)
+	if err := store.Write(bundle); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	pair, err := tls.LoadX509KeyPair(store.CertFile, store.KeyFile)
+	if err != nil {
+		t.Fatalf("load written key pair: %v", err)
+	}
+	if pair.Leaf == nil || pair.Leaf.SerialNumber.Int64() != 1 {
+		t.Fatalf("unexpected written leaf: %+v", pair.Leaf)
+	}
+	info, err := os.Stat(store.KeyFile)
+	if err != nil {
+		t.Fatalf("stat key: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("key mode = %o, want 600", perm)
+	}
+	caPEM, err := os.ReadFile(store.CAFile)
+	if err != nil {
+		t.Fatalf("read CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("expected the CA file to hold PEM certs")
+	}
+	if _, err := pair.Leaf.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Fatalf("written leaf does not verify against written CA: %v", err)
+	}
+
+	if err := store.Write(ca.bundle(t, 2, testSpiffeID)); err != nil {
+		t.Fatalf("second Write failed: %v", err)
+	}
+	pair, err = tls.LoadX509KeyPair(store.CertFile, store.KeyFile)
+	if err != nil || pair.Leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("expected files to be replaced with serial 2: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Fatalf("dir has %d entries, want 3 (no leftover temp files)", len(entries))
+	}
+
+	if err := store.Write(nil); err == nil {
+		t.Fatal("expected nil bundle to be rejected")
+	}
+}
@@ -0,0 +1,89 @@
+package certmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RotationOutcome describes the result of a rotation attempt.
+type RotationOutcome string
+
+const (
+	RotationRotated   RotationOutcome = "rotated"
+	RotationUnchanged RotationOutcome = "unchanged"
+	RotationFailed    RotationOutcome = "failed"
+)
+
+// RotationRecord is one entry in the manager's rotation history.
+type RotationRecord struct {
+	Time       time.Time
+	RotationID string
+	Outcome    RotationOutcome
+	// Info describes the current bundle after the attempt; empty on failure.
+	Info BundleInfo
+	Err  error
+}
+
+// History returns up to Options.HistorySize most recent rotation attempts,
+// oldest first.
+func (m *Manager) History() []RotationRecord {
+	return m.history.snapshot()
+}
+
+func (m *Manager) record(ctx context.Context, outcome RotationOutcome, bundle *Bundle, err error) {
+	if !m.history.enabled() {
+		return
+	}
+	rec := RotationRecord{
+		Time:       m.opts.Now(),
+		RotationID: RotationID(ctx),
+		Outcome:    outcome,
+		Err:        err,
+	}
+	if bundle != nil {
+		rec.Info = bundleInfo(bundle)
+		rec.Info.RotationID = rec.RotationID
+	}
+	m.history.add(rec)
+}
+
+// history is a fixed-size ring of rotation records.
+type history struct {
+	mu    sync.RWMutex
+	buf   []RotationRecord
+	next  int
+	count int
+}
+
+func newHistory(size int) history {
+	if size <= 0 {
+		return history{}
+	}
+	return history{buf: make([]RotationRecord, size)}
+}
+
+func (h *history) enabled() bool {
+	return len(h.buf) > 0
+}
+
+func (h *history) add(rec RotationRecord) {
+	h.mu.Lock()
+	h.buf[h.next] = rec
+	h.next = (h.next + 1) % len(h.buf)
+	if h.count < len(h.buf) {
+		h.count++
+	}
+	h.mu.Unlock()
+}
+
+func (h *history) snapshot() []RotationRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]RotationRecord, 0, h.count)
+	start := (h.next - h.count + len(h.buf)) % max(len(h.buf), 1)
+	for i := 0; i < h.count; i++ {
+		out = append(out, h.buf[(start+i)%len(h.buf)])
+	}
+	return out
+}
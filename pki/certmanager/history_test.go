@@ -0,0 +1,62 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestHistoryKeepsMostRecent(t *testing.T) {
+	t.Parallel()
+
+	newBundle := func(serial int64) *Bundle {
+		return &Bundle{
+			Cert:     &tls.Certificate{Leaf: &x509.Certificate{SerialNumber: big.NewInt(serial)}},
+			NotAfter: time.Now().Add(time.Hour),
+		}
+	}
+	issuer := &flakyIssuer{bundle: newBundle(1)}
+	mgr := NewWithOptions(issuer, Options{HistorySize: 3})
+
+	_, _ = mgr.tick(context.Background()) // rotated, serial 1
+	_, _ = mgr.tick(context.Background()) // unchanged
+	issuer.err = errors.New("down")
+	_, _ = mgr.tick(context.Background()) // failed
+	issuer.err = nil
+	issuer.bundle = newBundle(2)
+	_, _ = mgr.tick(context.Background()) // rotated, serial 2
+
+	got := mgr.History()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(got))
+	}
+	want := []RotationOutcome{RotationUnchanged, RotationFailed, RotationRotated}
+	for i, rec := range got {
+		if rec.Outcome != want[i] {
+			t.Fatalf("record %d outcome = %s, want %s", i, rec.Outcome, want[i])
+		}
+		if rec.RotationID == "" {
+			t.Fatalf("record %d missing rotation ID", i)
+		}
+	}
+	if got[1].Err == nil {
+		t.Fatal("expected failed record to carry the error")
+	}
+	if got[2].Info.SerialNumber != "2" {
+		t.Fatalf("latest serial = %q, want 2", got[2].Info.SerialNumber)
+	}
+}
+
+func TestHistoryDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	mgr := New(staticIssuer{bundle: &Bundle{NotAfter: time.Now().Add(time.Hour)}})
+	_, _ = mgr.tick(context.Background())
+	if got := mgr.History(); len(got) != 0 {
+		t.Fatalf("expected no history, got %d records", len(got))
+	}
+}
@@ -0,0 +1,195 @@
+// Package k8sevents publishes certmanager rotations and failures as
+// Kubernetes Events on the workload's pod, so they show up in
+// `kubectl get events` and `kubectl describe pod`. It talks to the
+// Kubernetes API over plain HTTP and needs no client libraries.
+package k8sevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+	"github.com/cmmoran/spiffe-rotate/pki/internal/kubeapi"
+)
+
+// Event reasons recorded by Recorder.
+const (
+	ReasonRotated = "CertificateRotated"
+	ReasonFailed  = "CertificateRotationFailed"
+)
+
+// maxMessage bounds event messages; the API server rejects much larger ones.
+const maxMessage = 1024
+
+// Recorder turns certmanager hooks into Kubernetes Events on a pod. Its
+// OnRotate and OnError methods match certmanager.Options.OnRotate and
+// OnError; the manager already runs hooks asynchronously under HookTimeout,
+// so a slow or unreachable API server never delays rotation. Failures to
+// record are logged and otherwise ignored.
+//
+// Inside a pod, the API server, token, CA and namespace default to the
+// service account's, which needs create on events. Expose the pod's name
+// (and optionally UID) through the downward API as POD_NAME and POD_UID.
+type Recorder struct {
+	// APIServer is the Kubernetes API URL. Defaults to the in-cluster
+	// KUBERNETES_SERVICE_HOST/PORT address.
+	APIServer string
+	// Token authenticates to the API server. TokenFile is re-read for every
+	// event so projected tokens keep working; it defaults to the service
+	// account token when Token is empty.
+	Token     string
+	TokenFile string
+	// HTTPClient defaults to a client trusting the service account CA.
+	HTTPClient *http.Client
+	// Namespace defaults to the pod's namespace.
+	Namespace string
+	// PodName is the pod events are recorded against. Defaults to the
+	// POD_NAME environment variable, then the hostname.
+	PodName string
+	// PodUID, if set, pins events to this pod instance. Defaults to the
+	// POD_UID environment variable.
+	PodUID string
+	// Component is the event source. Default: spiffe-rotate.
+	Component string
+	// Logger receives failures to record an event. Defaults to discarding
+	// output.
+	Logger *slog.Logger
+}
+
+// event is the subset of a core/v1 Event the recorder writes.
+type event struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		GenerateName string `json:"generateName"`
+		Namespace    string `json:"namespace"`
+	} `json:"metadata"`
+	InvolvedObject struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Namespace  string `json:"namespace"`
+		Name       string `json:"name"`
+		UID        string `json:"uid,omitempty"`
+	} `json:"involvedObject"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Source  struct {
+		Component string `json:"component"`
+	} `json:"source"`
+	FirstTimestamp     string `json:"firstTimestamp"`
+	LastTimestamp      string `json:"lastTimestamp"`
+	Count              int    `json:"count"`
+	ReportingComponent string `json:"reportingComponent"`
+	ReportingInstance  string `json:"reportingInstance"`
+}
+
+// OnRotate records a Normal CertificateRotated event.
+func (r *Recorder) OnRotate(ctx context.Context, info certmanager.BundleInfo) {
+	msg := fmt.Sprintf("Rotated certificate to serial %s, valid until %s",
+		info.SerialNumber, info.NotAfter.UTC().Format(time.RFC3339))
+	if len(info.URIs) > 0 {
+		msg += " for " + strings.Join(info.URIs, ", ")
+	}
+	if info.RotationID != "" {
+		msg += " (rotation " + info.RotationID + ")"
+	}
+	r.record(ctx, "Normal", ReasonRotated, msg)
+}
+
+// OnError records a Warning CertificateRotationFailed event.
+func (r *Recorder) OnError(ctx context.Context, err error) {
+	msg := "Certificate rotation failed: " + err.Error()
+	if id := certmanager.RotationID(ctx); id != "" {
+		msg += " (rotation " + id + ")"
+	}
+	r.record(ctx, "Warning", ReasonFailed, msg)
+}
+
+// Record posts an event against the pod and returns any error, for callers
+// that want to report their own reasons.
+func (r *Recorder) Record(ctx context.Context, eventType, reason, message string) error {
+	ns, err := kubeapi.Namespace(r.Namespace)
+	if err != nil {
+		return err
+	}
+	pod := r.podName()
+	if pod == "" {
+		return errors.New("pod name unknown: set PodName or POD_NAME")
+	}
+	message = truncate(message, maxMessage)
+	component := r.Component
+	if component == "" {
+		component = "spiffe-rotate"
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var ev event
+	ev.APIVersion, ev.Kind = "v1", "Event"
+	ev.Metadata.GenerateName = pod + "."
+	ev.Metadata.Namespace = ns
+	ev.InvolvedObject.APIVersion, ev.InvolvedObject.Kind = "v1", "Pod"
+	ev.InvolvedObject.Namespace, ev.InvolvedObject.Name = ns, pod
+	ev.InvolvedObject.UID = r.PodUID
+	if ev.InvolvedObject.UID == "" {
+		ev.InvolvedObject.UID = os.Getenv("POD_UID")
+	}
+	ev.Reason, ev.Message, ev.Type = reason, message, eventType
+	ev.Source.Component = component
+	ev.FirstTimestamp, ev.LastTimestamp, ev.Count = now, now, 1
+	ev.ReportingComponent, ev.ReportingInstance = component, pod
+
+	client := kubeapi.Client{
+		APIServer:  r.APIServer,
+		Token:      r.Token,
+		TokenFile:  r.TokenFile,
+		HTTPClient: r.HTTPClient,
+		Timeout:    10 * time.Second,
+	}
+	return client.Do(ctx, http.MethodPost, path.Join("/api/v1/namespaces", ns, "events"), ev, nil)
+}
+
+func (r *Recorder) record(ctx context.Context, eventType, reason, message string) {
+	if err := r.Record(ctx, eventType, reason, message); err != nil {
+		r.logger().WarnContext(ctx, "k8sevents: record event", "reason", reason, "error", err)
+	}
+}
+
+func (r *Recorder) podName() string {
+	if r.PodName != "" {
+		return r.PodName
+	}
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+func (r *Recorder) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.New(slog.DiscardHandler)
+}
+
+// truncate shortens s to at most n bytes, ending in "...", without splitting
+// a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - 3
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
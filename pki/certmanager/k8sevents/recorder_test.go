@@ -0,0 +1,114 @@
+package k8sevents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+)
+
+func TestRecorderPostsPodEvents(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		events []event
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/namespaces/apps/events" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"events is forbidden"}`))
+			return
+		}
+		var ev event
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	rec := &Recorder{
+		APIServer:  server.URL,
+		Token:      "sa-token",
+		HTTPClient: server.Client(),
+		Namespace:  "apps",
+		PodName:    "api-7d9f",
+		PodUID:     "uid-1",
+	}
+	rec.OnRotate(context.Background(), certmanager.BundleInfo{
+		SerialNumber: "42",
+		NotAfter:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		URIs:         []string{"spiffe://corp/apps/api"},
+		RotationID:   "r-1",
+	})
+	rec.OnError(context.Background(), errors.New("vault down: "+strings.Repeat("x", 2*maxMessage)))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(events))
+	}
+	rotated, failed := events[0], events[1]
+	if rotated.Type != "Normal" || rotated.Reason != ReasonRotated ||
+		!strings.Contains(rotated.Message, "serial 42") || !strings.Contains(rotated.Message, "spiffe://corp/apps/api") {
+		t.Fatalf("unexpected rotation event: %+v", rotated)
+	}
+	if obj := rotated.InvolvedObject; obj.Kind != "Pod" || obj.Name != "api-7d9f" || obj.Namespace != "apps" || obj.UID != "uid-1" {
+		t.Fatalf("unexpected involved object: %+v", obj)
+	}
+	if rotated.Metadata.GenerateName != "api-7d9f." || rotated.Source.Component != "spiffe-rotate" || rotated.Count != 1 {
+		t.Fatalf("unexpected event metadata: %+v", rotated)
+	}
+	if failed.Type != "Warning" || failed.Reason != ReasonFailed || !strings.Contains(failed.Message, "vault down") {
+		t.Fatalf("unexpected failure event: %+v", failed)
+	}
+	if len(failed.Message) > maxMessage {
+		t.Fatalf("message length = %d, want at most %d", len(failed.Message), maxMessage)
+	}
+}
+
+func TestRecorderReportsAPIErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"events is forbidden"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	rec := &Recorder{APIServer: server.URL, Token: "t", HTTPClient: server.Client(), Namespace: "apps", PodName: "api"}
+	err := rec.Record(context.Background(), "Normal", "Test", "hello")
+	if err == nil || !strings.Contains(err.Error(), "events is forbidden") {
+		t.Fatalf("Record() = %v, want the API error message", err)
+	}
+	// The hook form only logs.
+	rec.OnError(context.Background(), errors.New("boom"))
+}
+
+func TestTruncateKeepsRunes(t *testing.T) {
+	t.Parallel()
+
+	msg := strings.Repeat("é", maxMessage) // two bytes each
+	got := truncate(msg, maxMessage)
+	if len(got) > maxMessage || !utf8.ValidString(got) || !strings.HasSuffix(got, "...") {
+		t.Fatalf("truncate produced %d bytes, valid UTF-8 %v", len(got), utf8.ValidString(got))
+	}
+	if short := "short"; truncate(short, maxMessage) != short {
+		t.Fatal("expected short messages to be kept as is")
+	}
+}
@@ -0,0 +1,70 @@
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// KeyFormat selects the PEM encoding of exported private keys.
+type KeyFormat int
+
+const (
+	// KeyPKCS8 writes a "PRIVATE KEY" block; it supports every key type.
+	KeyPKCS8 KeyFormat = iota
+	// KeyPKCS1 writes an "RSA PRIVATE KEY" block; RSA keys only.
+	KeyPKCS1
+	// KeySEC1 writes an "EC PRIVATE KEY" block; ECDSA keys only.
+	KeySEC1
+)
+
+func (f KeyFormat) String() string {
+	switch f {
+	case KeyPKCS8:
+		return "PKCS#8"
+	case KeyPKCS1:
+		return "PKCS#1"
+	case KeySEC1:
+		return "SEC 1"
+	default:
+		return fmt.Sprintf("KeyFormat(%d)", int(f))
+	}
+}
+
+// MarshalPrivateKeyPEM encodes key in format, regardless of how the issuer
+// delivered it. Keys that cannot be exported (e.g. HSM-backed signers) or do
+// not fit format fail.
+func MarshalPrivateKeyPEM(key crypto.PrivateKey, format KeyFormat) ([]byte, error) {
+	var (
+		block = &pem.Block{}
+		err   error
+	)
+	switch format {
+	case KeyPKCS8:
+		block.Type = "PRIVATE KEY"
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+	case KeyPKCS1:
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA key, have %T", format, key)
+		}
+		block.Type = "RSA PRIVATE KEY"
+		block.Bytes = x509.MarshalPKCS1PrivateKey(rsaKey)
+	case KeySEC1:
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an ECDSA key, have %T", format, key)
+		}
+		block.Type = "EC PRIVATE KEY"
+		block.Bytes, err = x509.MarshalECPrivateKey(ecKey)
+	default:
+		return nil, fmt.Errorf("unknown key format %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s key: %w", format, err)
+	}
+	return pem.EncodeToMemory(block), nil
+}
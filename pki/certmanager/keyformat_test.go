@@ -0,0 +1,95 @@
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarshalPrivateKeyPEM(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		key       interface{ Equal(crypto.PrivateKey) bool }
+		format    KeyFormat
+		blockType string
+		parse     func([]byte) (any, error)
+	}{
+		{"rsa pkcs8", rsaKey, KeyPKCS8, "PRIVATE KEY", x509.ParsePKCS8PrivateKey},
+		{"rsa pkcs1", rsaKey, KeyPKCS1, "RSA PRIVATE KEY", func(der []byte) (any, error) { return x509.ParsePKCS1PrivateKey(der) }},
+		{"ec pkcs8", ecKey, KeyPKCS8, "PRIVATE KEY", x509.ParsePKCS8PrivateKey},
+		{"ec sec1", ecKey, KeySEC1, "EC PRIVATE KEY", func(der []byte) (any, error) { return x509.ParseECPrivateKey(der) }},
+		{"ed25519 pkcs8", edKey, KeyPKCS8, "PRIVATE KEY", x509.ParsePKCS8PrivateKey},
+	} {
+		data, err := MarshalPrivateKeyPEM(tc.key, tc.format)
+		if err != nil {
+			t.Fatalf("%s: MarshalPrivateKeyPEM failed: %v", tc.name, err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != tc.blockType {
+			t.Fatalf("%s: got block %v, want %q", tc.name, block, tc.blockType)
+		}
+		parsed, err := tc.parse(block.Bytes)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tc.name, err)
+		}
+		if !tc.key.Equal(parsed) {
+			t.Fatalf("%s: round-tripped key differs", tc.name)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		key    any
+		format KeyFormat
+	}{
+		{"ec as pkcs1", ecKey, KeyPKCS1},
+		{"rsa as sec1", rsaKey, KeySEC1},
+		{"ed25519 as sec1", edKey, KeySEC1},
+		{"unknown format", ecKey, KeyFormat(9)},
+	} {
+		if _, err := MarshalPrivateKeyPEM(tc.key, tc.format); err == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestFileStoreKeyFormat(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := FileStore{KeyFile: filepath.Join(dir, "tls.key"), KeyFormat: KeySEC1}
+	bundle := newTestCA(t).bundle(t, 1, testSpiffeID)
+	if err := store.Write(bundle); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := os.ReadFile(store.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(data); block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Fatalf("got key block %v, want EC PRIVATE KEY", block)
+	}
+}
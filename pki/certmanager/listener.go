@@ -0,0 +1,117 @@
+package certmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+
+	"github.com/cmmoran/spiffe-rotate/pki/spiffe"
+)
+
+// Listen returns a TLS listener that serves the manager's current bundle and
+// requires client certs authorized by auth. The config is resolved per
+// handshake, so rotations take effect without re-listening. If the manager is
+// not ready, the handshake on the accepted connection fails with ErrNotReady.
+// When auth.VerifiesRaw, client chains are verified by auth (e.g. against
+// federated bundles) instead of the manager's CA pool.
+func Listen(network, addr string, m *Manager, auth spiffe.Authorizer) (net.Listener, error) {
+	inner, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(inner, serverConfig(m, auth)), nil
+}
+
+func serverConfig(m *Manager, auth spiffe.Authorizer) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			b, err := m.Current()
+			if err != nil {
+				return nil, err
+			}
+			cert, err := m.GetCertificate(hello)
+			if err != nil {
+				return nil, err
+			}
+			clientAuth := tls.RequireAndVerifyClientCert
+			if auth.VerifiesRaw() {
+				clientAuth = tls.RequireAnyClientCert
+			}
+			return &tls.Config{
+				MinVersion:            tls.VersionTLS12,
+				ClientAuth:            clientAuth,
+				Certificates:          []tls.Certificate{*cert},
+				ClientCAs:             m.trustPool(b),
+				VerifyPeerCertificate: auth.VerifyPeerCertificate,
+			}, nil
+		},
+	}
+}
+
+// CurrentCAPool returns a copy of the current bundle's CA pool, plus
+// Options.AdditionalTrust, for libraries that only accept a static RootCAs or
+// ClientCAs. The copy does not follow rotations: refresh it from OnCAChange,
+// or prefer Listen, Transport or ServerConfig, which resolve the pool per
+// handshake.
+func (m *Manager) CurrentCAPool() (*x509.CertPool, error) {
+	b, err := m.Current()
+	if err != nil {
+		return nil, err
+	}
+	pool := m.trustPool(b)
+	if pool == nil {
+		return x509.NewCertPool(), nil
+	}
+	return pool.Clone(), nil
+}
+
+// trustPool pairs a bundle with its CA pool combined with AdditionalTrust.
+type trustPool struct {
+	base *Bundle
+	pool *x509.CertPool
+}
+
+// trustPool returns the pool peers are verified against for b: b.CA, or
+// with AdditionalTrust, a union cached until the bundle changes.
+func (m *Manager) trustPool(b *Bundle) *x509.CertPool {
+	if m.opts.AdditionalTrust == nil {
+		return b.CA
+	}
+	if t := m.trust.Load(); t != nil && t.base == b {
+		return t.pool
+	}
+	pool := m.opts.AdditionalTrust.Clone()
+	for _, cert := range b.CACerts {
+		pool.AddCert(cert)
+	}
+	m.trust.Store(&trustPool{base: b, pool: pool})
+	return pool
+}
+
+// ServerConfig returns a copy of base whose GetConfigForClient sets ClientCAs
+// to the current CA pool, plus Options.AdditionalTrust, for each handshake,
+// so client cert verification follows rotations. If base sets neither Certificates nor
+// GetCertificate, the manager's GetCertificate is used. A GetConfigForClient
+// already on base is replaced.
+func (m *Manager) ServerConfig(base *tls.Config) *tls.Config {
+	if base == nil {
+		base = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	inner := base.Clone()
+	inner.GetConfigForClient = nil
+	if len(inner.Certificates) == 0 && inner.GetCertificate == nil {
+		inner.GetCertificate = m.GetCertificate
+	}
+	cfg := inner.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		b, err := m.Current()
+		if err != nil {
+			return nil, err
+		}
+		conn := inner.Clone()
+		conn.ClientCAs = m.trustPool(b)
+		return conn, nil
+	}
+	return cfg
+}
@@ -0,0 +1,175 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/cmmoran/spiffe-rotate/pki/spiffe"
+)
+
+const testSpiffeID = "spiffe://corp/prod/stack/payments/service/api"
+
+func TestListenServesManagerBundle(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	mgr := New(staticIssuer{bundle: ca.bundle(t, 1, testSpiffeID)})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	ln, err := Listen("tcp", "127.0.0.1:0", mgr, spiffe.Authorizer{
+		AllowedPrefixes: []string{"spiffe://corp/prod/stack/payments/"},
+	})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		errCh <- conn.(*tls.Conn).Handshake()
+	}()
+
+	client := ca.bundle(t, 2, testSpiffeID)
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		RootCAs:      client.CA,
+		Certificates: []tls.Certificate{*client.Cert},
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	_ = conn.Close()
+	if err := <-errCh; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+}
+
+func TestListenNotReadyFailsHandshake(t *testing.T) {
+	t.Parallel()
+
+	mgr := New(staticIssuer{err: errors.New("not issued")})
+	ln, err := Listen("tcp", "127.0.0.1:0", mgr, spiffe.Authorizer{})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		errCh <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("expected client handshake to fail")
+	}
+	if err := <-errCh; !errors.Is(err, ErrNotReady) {
+		t.Fatalf("server handshake error = %v, want ErrNotReady", err)
+	}
+}
+
+func TestCurrentCAPoolAndServerConfig(t *testing.T) {
+	t.Parallel()
+
+	caA, caB := newTestCA(t), newTestCA(t)
+	issuer := &sequenceIssuer{bundles: []*Bundle{caA.bundle(t, 1, testSpiffeID), caB.bundle(t, 2, testSpiffeID)}}
+	mgr := New(issuer)
+	if _, err := mgr.CurrentCAPool(); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("CurrentCAPool err = %v, want ErrNotReady", err)
+	}
+	cfg := mgr.ServerConfig(&tls.Config{MinVersion: tls.VersionTLS13, ClientAuth: tls.RequireAndVerifyClientCert})
+	if _, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{}); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("GetConfigForClient err = %v, want ErrNotReady", err)
+	}
+
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	static, err := mgr.CurrentCAPool()
+	if err != nil {
+		t.Fatalf("CurrentCAPool failed: %v", err)
+	}
+	if err := mgr.Rotate(context.Background()); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	leafB := caB.bundle(t, 3, testSpiffeID).Cert.Leaf
+	if _, err := leafB.Verify(x509.VerifyOptions{Roots: static}); err == nil {
+		t.Fatal("expected the static pool copy not to follow rotation")
+	}
+	conn, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetConfigForClient failed: %v", err)
+	}
+	if _, err := leafB.Verify(x509.VerifyOptions{Roots: conn.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Fatalf("expected per-handshake ClientCAs to follow rotation: %v", err)
+	}
+	if conn.MinVersion != tls.VersionTLS13 || conn.ClientAuth != tls.RequireAndVerifyClientCert || conn.GetCertificate == nil {
+		t.Fatalf("expected base settings and GetCertificate to carry over: %+v", conn)
+	}
+}
+
+func TestAdditionalTrust(t *testing.T) {
+	t.Parallel()
+
+	issuing, org := newTestCA(t), newTestCA(t)
+	extra := x509.NewCertPool()
+	extra.AddCert(org.cert)
+	mgr := NewWithOptions(staticIssuer{bundle: issuing.bundle(t, 1, testSpiffeID)}, Options{AdditionalTrust: extra})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	conn, err := mgr.ServerConfig(nil).GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetConfigForClient failed: %v", err)
+	}
+	static, err := mgr.CurrentCAPool()
+	if err != nil {
+		t.Fatalf("CurrentCAPool failed: %v", err)
+	}
+	for name, leaf := range map[string]*x509.Certificate{
+		"issuing CA": issuing.bundle(t, 2, testSpiffeID).Cert.Leaf,
+		"org root":   org.bundle(t, 3, testSpiffeID).Cert.Leaf,
+	} {
+		opts := x509.VerifyOptions{Roots: conn.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+		if _, err := leaf.Verify(opts); err != nil {
+			t.Fatalf("%s: ClientCAs rejected the leaf: %v", name, err)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: static}); err != nil {
+			t.Fatalf("%s: CurrentCAPool rejected the leaf: %v", name, err)
+		}
+		if _, err := mgr.verifyPeer([]*x509.Certificate{leaf}, x509.ExtKeyUsageServerAuth); err != nil {
+			t.Fatalf("%s: Transport verification rejected the leaf: %v", name, err)
+		}
+	}
+
+	stranger := newTestCA(t).bundle(t, 4, testSpiffeID).Cert.Leaf
+	if _, err := mgr.verifyPeer([]*x509.Certificate{stranger}, x509.ExtKeyUsageServerAuth); err == nil {
+		t.Fatal("expected a leaf from an unrelated CA to be rejected")
+	}
+	if b, _ := mgr.Current(); b.CA == conn.ClientCAs {
+		t.Fatal("expected the bundle's own pool to be left unmodified")
+	}
+}
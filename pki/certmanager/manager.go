@@ -2,29 +2,80 @@ package certmanager
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-var ErrNotReady = errors.New("cert bundle not ready")
+var (
+	ErrNotReady  = errors.New("cert bundle not ready")
+	ErrNilBundle = errors.New("issuer returned nil bundle")
+	// ErrClockSkew is reported via OnError when a freshly issued leaf is not
+	// valid at Options.Now, which usually means the host clock is wrong.
+	ErrClockSkew = errors.New("host clock outside issued cert validity")
+	ErrNoPending = errors.New("no staged bundle to commit")
+	// ErrStalePending is returned by Commit when a rotation since Stage
+	// already serves a cert that outlives the staged one.
+	ErrStalePending = errors.New("staged bundle is older than the current bundle")
+)
+
+// CoalescedError is passed to OnError when identical consecutive errors were
+// suppressed by Options.ErrorHookInterval since the last notification.
+type CoalescedError struct {
+	Err        error
+	Suppressed int
+}
+
+func (e *CoalescedError) Error() string {
+	return fmt.Sprintf("%v (repeated %d more times)", e.Err, e.Suppressed)
+}
+
+func (e *CoalescedError) Unwrap() error {
+	return e.Err
+}
 
 // Bundle holds the active leaf cert and the trust pool.
 type Bundle struct {
-	Cert     *tls.Certificate
-	CA       *x509.CertPool
+	Cert *tls.Certificate
+	CA   *x509.CertPool
+	// CACerts lists the certificates in CA, if known. x509.CertPool cannot be
+	// enumerated, so issuers should populate this to enable CA change tracking.
+	CACerts  []*x509.Certificate
 	NotAfter time.Time
 }
 
 // BundleInfo is a read-only view of a bundle for hooks.
 type BundleInfo struct {
-	NotAfter     time.Time
-	CommonName   string
+	NotAfter   time.Time
+	CommonName string
+	// SerialNumber is the leaf's certificate serial in decimal, not the
+	// subject serialNumber attribute.
 	SerialNumber string
 	DNSNames     []string
 	URIs         []string
+	// CAFingerprints holds the sorted hex SHA-256 fingerprints of Bundle.CACerts.
+	CAFingerprints []string
+	// RotationID identifies the refresh attempt that produced this bundle.
+	RotationID string
+}
+
+// CAExpiry describes a CA cert that expires before the next scheduled rotation.
+type CAExpiry struct {
+	Subject     string
+	Fingerprint string
+	NotAfter    time.Time
 }
 
 // Issuer produces a new cert bundle.
@@ -33,14 +84,95 @@ type Issuer interface {
 }
 
 type Options struct {
-	MinRefresh   time.Duration
+	MinRefresh time.Duration
+	// MinRefreshFraction, when in (0, 1), adds a floor of the bundle's
+	// remaining validity times this fraction. The larger of MinRefresh and
+	// the fractional floor wins; MinRefresh keeps its default when unset.
+	MinRefreshFraction float64
+	// JitterMargin, in (0, 1), is the final fraction of the cert's lifetime
+	// that Run's random delay may not push a rotation into. It only bounds
+	// the jitter: a schedule already inside the margin, e.g. due to
+	// MinRefresh, is kept. Default 0.1.
+	JitterMargin float64
 	ErrorBackoff time.Duration
 	HookTimeout  time.Duration
 	// OnRotate is a best-effort notification hook. BundleInfo is read-only.
 	OnRotate func(context.Context, BundleInfo)
 	// OnError is a best-effort notification hook.
 	OnError func(context.Context, error)
-	Now     func() time.Time
+	// OnRecovered is a best-effort notification hook fired when an issuance
+	// succeeds after one or more consecutive failures, e.g. to resolve an
+	// alert raised from OnError. Unlike OnRotate it fires only on that
+	// failure-to-success transition, and also when the issuer returned an
+	// unchanged bundle. A bundle from Stage counts once it is committed.
+	OnRecovered func(context.Context, BundleInfo)
+	// ErrorHookInterval coalesces identical consecutive errors: OnError fires
+	// on the first occurrence and then at most once per interval, receiving a
+	// *CoalescedError with the suppressed count. Zero reports every error.
+	ErrorHookInterval time.Duration
+	// OnCAChange is a best-effort notification hook fired with the sorted CA
+	// fingerprints when the set of CA certs differs from the previous bundle.
+	OnCAChange func(context.Context, []string)
+	// OnCAExpiryWarning is a best-effort notification hook fired after a
+	// rotation when any CA cert expires before the next scheduled rotation.
+	OnCAExpiryWarning func(context.Context, []CAExpiry)
+	Now               func() time.Time
+	// Preflight, if set, is called by Start before the initial issuance so
+	// startup fails with a clear cause (e.g. vault.Client.Health).
+	Preflight func(context.Context) error
+	// Logger receives diagnostic messages. Defaults to discarding output.
+	Logger *slog.Logger
+	// SNIIssuers maps TLS server names to issuers whose bundles GetCertificate
+	// serves for matching ClientHello.ServerName values (case-insensitive).
+	// Each rotates on its own schedule with the same options; other names
+	// fall back to the manager's default issuer.
+	SNIIssuers map[string]Issuer
+	// ClientIssuer, if set, issues the bundle GetClientCertificate presents,
+	// e.g. from a role with client-auth EKU and a distinct identity, while
+	// the manager's issuer keeps serving GetCertificate. It rotates on its own
+	// schedule with the same options; peers are still verified against the
+	// manager's own bundle CA pool.
+	ClientIssuer Issuer
+	// AdditionalTrust, if set, is trusted alongside each bundle's CA certs
+	// when Listen, ServerConfig, Transport and CurrentCAPool verify peers,
+	// e.g. an org-wide root or a pool fed by an SDS server. It is combined
+	// with Bundle.CACerts, so issuers must fill those in, and must not be
+	// modified once the manager is in use.
+	AdditionalTrust *x509.CertPool
+	// StartRetries retries Start's initial issuance up to this many times
+	// when it fails to reach the issuer (DNS or connection errors), e.g.
+	// while the issuer's Service is not yet resolvable during pod startup.
+	// Other errors fail Start immediately.
+	StartRetries int
+	// StartRetryBackoff is the wait between those retries. Default: 1s.
+	StartRetryBackoff time.Duration
+	// HistorySize is the number of rotation attempts kept for History.
+	// Zero disables history.
+	HistorySize int
+	// RecoveryTTL, if set, is offered to the issuer via RecoveryTTL(ctx) on
+	// the first issuance after failed attempts, so the recovery cert expires
+	// sooner and the issuance path is re-validated early. Issuers that honor
+	// it (e.g. vault.Issuer) revert to their configured TTL afterwards.
+	RecoveryTTL time.Duration
+	// BootstrapSelfSigned makes GetCertificate and GetClientCertificate serve
+	// an ephemeral self-signed cert, generated by New, until the first real
+	// bundle is stored. Peers will reject it: it only lets a listener bind and
+	// answer internal health checks while the issuer is unreachable. Current
+	// still returns ErrNotReady until the first issuance.
+	BootstrapSelfSigned bool
+	// StapleOCSP makes Run fetch an OCSP response for the current leaf from
+	// the responder in its AIA extension and staple it to served certs. The
+	// staple is refetched after each rotation and halfway to its nextUpdate;
+	// failures go to OnError and the previous staple is dropped on rotation.
+	StapleOCSP bool
+	// PreviousRetention keeps the bundle replaced by a rotation available
+	// from Previous for this long, e.g. so custom VerifyPeerCertificate logic
+	// for self-connections can accept a peer still presenting the old leaf.
+	// Zero disables it.
+	PreviousRetention time.Duration
+	// OCSPResponderURL fetches staples from this responder instead of the
+	// leaf's AIA, e.g. in air-gapped setups. Setting it implies StapleOCSP.
+	OCSPResponderURL string
 }
 
 // Manager rotates certs in-process and swaps them atomically.
@@ -48,6 +180,50 @@ type Manager struct {
 	issuer Issuer
 	curr   atomic.Value // *Bundle
 	opts   Options
+
+	errMu      sync.Mutex
+	lastErr    error
+	lastErrAt  time.Time
+	suppressed int
+
+	history history
+
+	sni    map[string]*Manager
+	client *Manager // from Options.ClientIssuer
+
+	bootstrap *tls.Certificate
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	failures atomic.Int32 // consecutive failed issuances
+	next     atomic.Int64 // scheduled rotation in Run, unix nanos; 0 if none
+
+	trust    atomic.Pointer[trustPool]
+	staple   atomic.Pointer[ocspStaple]
+	ocspKick chan struct{} // signals runOCSP that the bundle changed
+
+	prev atomic.Pointer[retainedBundle] // from Options.PreviousRetention
+
+	pendMu    sync.Mutex
+	pending   *Bundle
+	pendingID string
+
+	rotMu sync.Mutex
+	rot   *rotationSignal // closed and replaced on each rotation
+}
+
+// rotationSignal is closed when the next rotation completes, after info is
+// set to describe it.
+type rotationSignal struct {
+	done chan struct{}
+	info BundleInfo
+}
+
+// retainedBundle is a replaced bundle kept by Previous until expires.
+type retainedBundle struct {
+	bundle  *Bundle
+	expires time.Time
 }
 
 func New(issuer Issuer) *Manager {
@@ -64,13 +240,49 @@ func NewWithOptions(issuer Issuer, opts Options) *Manager {
 	if opts.HookTimeout <= 0 {
 		opts.HookTimeout = 2 * time.Second
 	}
+	if opts.StartRetryBackoff <= 0 {
+		opts.StartRetryBackoff = time.Second
+	}
+	if opts.JitterMargin <= 0 || opts.JitterMargin >= 1 {
+		opts.JitterMargin = 0.1
+	}
 	if opts.Now == nil {
 		opts.Now = time.Now
 	}
-	return &Manager{
-		issuer: issuer,
-		opts:   opts,
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	m := &Manager{
+		issuer:   issuer,
+		opts:     opts,
+		history:  newHistory(opts.HistorySize),
+		ready:    make(chan struct{}),
+		ocspKick: make(chan struct{}, 1),
+		rot:      &rotationSignal{done: make(chan struct{})},
+	}
+	if opts.BootstrapSelfSigned {
+		cert, err := selfSignedCert(opts.Now())
+		if err != nil {
+			opts.Logger.Warn("certmanager: bootstrap self-signed cert unavailable", "error", err)
+		}
+		m.bootstrap = cert
+	}
+	sub := opts
+	sub.SNIIssuers = nil
+	sub.ClientIssuer = nil
+	sub.Preflight = nil
+	if opts.ClientIssuer != nil {
+		client := sub
+		client.StapleOCSP, client.OCSPResponderURL = false, ""
+		m.client = NewWithOptions(opts.ClientIssuer, client)
+	}
+	if len(opts.SNIIssuers) > 0 {
+		m.sni = make(map[string]*Manager, len(opts.SNIIssuers))
+		for name, iss := range opts.SNIIssuers {
+			m.sni[strings.ToLower(name)] = NewWithOptions(iss, sub)
+		}
 	}
+	return m
 }
 
 // Current returns the current bundle or ErrNotReady.
@@ -81,94 +293,636 @@ func (m *Manager) Current() (*Bundle, error) {
 	return nil, ErrNotReady
 }
 
-// Start fetches the initial bundle.
+// Ready returns a channel that is closed once the first bundle is stored,
+// e.g. to wait before serving after launching Run in a goroutine.
+func (m *Manager) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// Start runs the optional preflight and fetches the initial bundle.
 func (m *Manager) Start(ctx context.Context) error {
-	_, _, err := m.refresh(ctx)
-	return err
+	if m.opts.Preflight != nil {
+		if err := m.opts.Preflight(ctx); err != nil {
+			return fmt.Errorf("preflight: %w", err)
+		}
+	}
+	err := m.startRefresh(ctx)
+	errs := []error{err}
+	for name, sub := range m.sni {
+		if err := sub.Start(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("server name %s: %w", name, err))
+		}
+	}
+	if m.client != nil {
+		if err := m.client.Start(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("client issuer: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startRefresh performs the initial issuance, retrying connection errors
+// per StartRetries.
+func (m *Manager) startRefresh(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		_, _, _, err := m.refresh(ctx)
+		if err == nil || attempt >= m.opts.StartRetries || !isConnError(err) {
+			return err
+		}
+		m.opts.Logger.WarnContext(ctx, "certmanager: issuer unreachable at startup, retrying",
+			"error", err, "attempt", attempt+1, "retries", m.opts.StartRetries, "backoff", m.opts.StartRetryBackoff)
+		if !m.sleep(ctx, m.opts.StartRetryBackoff) {
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+// isConnError reports whether err is a DNS or connection failure, i.e. the
+// issuer was not reached at all.
+func isConnError(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// StartInfo is like Start but also returns a read-only view of the primed
+// bundle, e.g. for logging the initial identity and expiry at boot.
+func (m *Manager) StartInfo(ctx context.Context) (BundleInfo, error) {
+	if err := m.Start(ctx); err != nil {
+		return BundleInfo{}, err
+	}
+	b, err := m.Current()
+	if err != nil {
+		return BundleInfo{}, err
+	}
+	return bundleInfo(b), nil
+}
+
+// StartAsync launches Run in the background and returns immediately, for
+// services that must start serving before the first issuance. Ready and
+// Current reflect when the first bundle lands; until then GetCertificate
+// returns ErrNotReady unless BootstrapSelfSigned is set. Preflight is not
+// run. The returned channel is closed when Run returns after ctx ends.
+func (m *Manager) StartAsync(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(ctx)
+	}()
+	return done
 }
 
 // Run continuously refreshes the bundle until ctx is canceled.
 func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	subs := slices.Collect(maps.Values(m.sni))
+	if m.client != nil {
+		subs = append(subs, m.client)
+	}
+	for _, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub.Run(ctx)
+		}()
+	}
+	if m.staplingEnabled() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.runOCSP(ctx)
+		}()
+	}
+
 	if _, err := m.Current(); err != nil {
-		if _, _, err := m.refresh(ctx); err != nil {
-			m.onError(err)
+		rctx := withRotationID(ctx)
+		if _, _, _, err := m.refresh(rctx); err != nil {
+			m.onError(rctx, err)
 		}
 	}
 
+	defer m.next.Store(0)
 	for {
-		bundle, next, err := m.refresh(ctx)
-		if err != nil {
-			m.onError(err)
-			if !m.sleep(ctx, m.opts.ErrorBackoff) {
-				return
-			}
-			continue
+		wait, _ := m.tick(ctx)
+		if !m.sleep(ctx, wait) {
+			return
 		}
-		m.onRotate(bundle)
+	}
+}
+
+// NextRotation returns when Run will next rotate, including error backoff
+// retries, e.g. for an admin endpoint showing "next rotation in 12m". It
+// reports false while Run is not scheduling rotations.
+func (m *Manager) NextRotation() (time.Time, bool) {
+	next := m.next.Load()
+	if next == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, next), true
+}
+
+// TimeToExpiry returns how long the current bundle remains valid by
+// Options.Now, e.g. for a readiness gauge; it is negative once the bundle
+// has expired. It reports false if no bundle is stored yet.
+func (m *Manager) TimeToExpiry() (time.Duration, bool) {
+	b, err := m.Current()
+	if err != nil {
+		return 0, false
+	}
+	return b.NotAfter.Sub(m.opts.Now()), true
+}
+
+// Previous returns the bundle replaced by the most recent rotation, for
+// Options.PreviousRetention after the swap as measured by Options.Now. It
+// reports false when retention is disabled, no rotation has happened yet,
+// or the window has passed, after which the bundle is released.
+func (m *Manager) Previous() (*Bundle, bool) {
+	r := m.prev.Load()
+	if r == nil {
+		return nil, false
+	}
+	if !m.opts.Now().Before(r.expires) {
+		m.prev.CompareAndSwap(r, nil)
+		return nil, false
+	}
+	return r.bundle, true
+}
+
+// tick performs one step of Run: it rotates once and returns how long to
+// wait before the next step, which NextRotation reports. Errors are reported
+// via OnError and returned.
+func (m *Manager) tick(ctx context.Context) (time.Duration, error) {
+	ctx = withRotationID(ctx)
+	_, next, err := m.rotate(ctx)
+	if err != nil {
+		m.onError(ctx, err)
+		m.next.Store(m.opts.Now().Add(m.opts.ErrorBackoff).UnixNano())
+		return m.opts.ErrorBackoff, err
+	}
+	m.resetErrors()
+
+	wait := m.refreshWait(next)
+	wait += m.jitter(wait)
+	m.next.Store(m.opts.Now().Add(wait).UnixNano())
+	m.checkCAExpiry(ctx, m.opts.Now().Add(wait))
+	return wait, nil
+}
+
+// jitter returns a delay of up to 10% of wait, derived from the clock, so
+// replicas do not rotate in lockstep. It is bounded so the rotation still
+// happens before the last JitterMargin of the current cert's lifetime.
+func (m *Manager) jitter(wait time.Duration) time.Duration {
+	now := m.opts.Now()
+	jitter := time.Duration(now.UnixNano() % int64(wait/10+1))
+	b, err := m.Current()
+	if err != nil {
+		return jitter
+	}
+	lifetime := b.NotAfter.Sub(now)
+	if leaf := leafCert(b); leaf != nil && leaf.NotAfter.After(leaf.NotBefore) {
+		lifetime = leaf.NotAfter.Sub(leaf.NotBefore)
+	}
+	deadline := b.NotAfter.Add(-time.Duration(float64(lifetime) * m.opts.JitterMargin))
+	if room := deadline.Sub(now.Add(wait)); jitter > room {
+		jitter = max(room, 0)
+	}
+	return jitter
+}
 
-		wait := next.Sub(m.opts.Now())
-		if wait < m.opts.MinRefresh {
-			wait = m.opts.MinRefresh
+// checkCAExpiry fires OnCAExpiryWarning for CA certs expiring before deadline.
+func (m *Manager) checkCAExpiry(ctx context.Context, deadline time.Time) {
+	if m.opts.OnCAExpiryWarning == nil {
+		return
+	}
+	b, err := m.Current()
+	if err != nil {
+		return
+	}
+	var expiring []CAExpiry
+	for _, cert := range b.CACerts {
+		if cert == nil || !cert.NotAfter.Before(deadline) {
+			continue
 		}
-		jitter := time.Duration(m.opts.Now().UnixNano() % int64(wait/10+1))
-		wait += jitter
+		expiring = append(expiring, CAExpiry{
+			Subject:     cert.Subject.String(),
+			Fingerprint: fingerprint(cert),
+			NotAfter:    cert.NotAfter,
+		})
+	}
+	if len(expiring) == 0 {
+		return
+	}
+	hctx, cancel := m.hookContext(ctx)
+	go func() {
+		defer cancel()
+		m.opts.OnCAExpiryWarning(hctx, expiring)
+	}()
+}
 
-		if !m.sleep(ctx, wait) {
-			return
+// GetCertificate is a tls.Config GetCertificate callback. With SNIIssuers,
+// the bundle for hello.ServerName is served when one is configured.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello != nil && m.sni != nil {
+		if sub, ok := m.sni[strings.ToLower(hello.ServerName)]; ok {
+			return sub.GetCertificate(hello)
 		}
 	}
+	return m.servingCert()
+}
+
+// GetClientCertificate is a tls.Config GetClientCertificate callback. With
+// ClientIssuer, its bundle is presented instead of the manager's own.
+func (m *Manager) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if m.client != nil {
+		return m.client.servingCert()
+	}
+	return m.servingCert()
 }
 
-// GetCertificate is a tls.Config GetCertificate callback.
-func (m *Manager) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+// servingCert returns the current leaf, or the bootstrap cert if no bundle
+// has been issued yet.
+func (m *Manager) servingCert() (*tls.Certificate, error) {
 	b, err := m.Current()
 	if err != nil {
+		if m.bootstrap != nil {
+			return m.bootstrap, nil
+		}
 		return nil, err
 	}
-	return b.Cert, nil
+	return m.stapled(b.Cert), nil
 }
 
-// GetClientCertificate is a tls.Config GetClientCertificate callback.
-func (m *Manager) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
-	b, err := m.Current()
+// refreshWait returns the time until next, clamped to the refresh floor.
+func (m *Manager) refreshWait(next time.Time) time.Duration {
+	now := m.opts.Now()
+	floor := m.opts.MinRefresh
+	if f := m.opts.MinRefreshFraction; f > 0 && f < 1 {
+		if b, err := m.Current(); err == nil {
+			if frac := time.Duration(float64(b.NotAfter.Sub(now)) * f); frac > floor {
+				floor = frac
+			}
+		}
+	}
+	wait := next.Sub(now)
+	if b, err := m.Current(); err == nil && wait > 0 && b.NotAfter.Sub(now) <= floor {
+		// Clamping up to the floor would serve an expired cert; rotate at
+		// the scheduled fraction of its actual lifetime instead.
+		return wait
+	}
+	if wait < floor {
+		wait = floor
+	}
+	return wait
+}
+
+// Rotate issues a new bundle immediately, outside Run's schedule. Hooks and
+// History behave as for a scheduled rotation; errors are returned rather
+// than reported via OnError.
+func (m *Manager) Rotate(ctx context.Context) error {
+	_, err := m.RotateAndGet(ctx)
+	return err
+}
+
+// RotateAndGet is like Rotate but returns the resulting bundle, e.g. to
+// confirm the new serial during a coordinated rotation. If the issuer
+// returned the same leaf, the unchanged current bundle is returned.
+func (m *Manager) RotateAndGet(ctx context.Context) (*Bundle, error) {
+	bundle, _, err := m.rotate(withRotationID(ctx))
+	return bundle, err
+}
+
+// RotateIfOlderThan rotates like Rotate, but only if the current leaf's
+// NotBefore is more than age ago or no bundle is stored yet. Run before a
+// planned issuer outage, it leaves every cert with close to a full lifetime
+// without churning certs that are already fresh.
+func (m *Manager) RotateIfOlderThan(ctx context.Context, age time.Duration) error {
+	if b, err := m.Current(); err == nil {
+		if leaf := leafCert(b); leaf != nil && m.opts.Now().Sub(leaf.NotBefore) <= age {
+			return nil
+		}
+	}
+	return m.Rotate(ctx)
+}
+
+// rotate refreshes the bundle and notifies OnRotate if it changed.
+func (m *Manager) rotate(ctx context.Context) (*Bundle, time.Time, error) {
+	prev, _ := m.Current()
+	bundle, next, changed, err := m.refresh(ctx)
+	switch {
+	case err != nil:
+		m.record(ctx, RotationFailed, nil, err)
+		return nil, time.Time{}, err
+	case !changed:
+		m.record(ctx, RotationUnchanged, bundle, nil)
+		return bundle, next, nil
+	}
+	m.rotated(ctx, prev, bundle)
+	return bundle, next, nil
+}
+
+// rotated records a bundle that replaced prev and notifies the hooks.
+func (m *Manager) rotated(ctx context.Context, prev, bundle *Bundle) {
+	m.record(ctx, RotationRotated, bundle, nil)
+	m.signalRotation(ctx, bundle)
+	m.onRotate(ctx, bundle)
+	if fps := caFingerprints(bundle); !slices.Equal(caFingerprints(prev), fps) {
+		m.onCAChange(ctx, fps)
+	}
+}
+
+// WaitForRotation blocks until the next bundle replaces the current one,
+// whether by Run, Rotate or Commit, and returns its info. Rotations that
+// return an unchanged bundle do not count. It returns ctx.Err() if ctx ends
+// first.
+func (m *Manager) WaitForRotation(ctx context.Context) (BundleInfo, error) {
+	m.rotMu.Lock()
+	sig := m.rot
+	m.rotMu.Unlock()
+	select {
+	case <-sig.done:
+		return sig.info, nil
+	case <-ctx.Done():
+		return BundleInfo{}, ctx.Err()
+	}
+}
+
+// signalRotation wakes WaitForRotation callers with bundle's info.
+func (m *Manager) signalRotation(ctx context.Context, bundle *Bundle) {
+	info := bundleInfo(bundle)
+	info.RotationID = RotationID(ctx)
+	m.rotMu.Lock()
+	sig := m.rot
+	m.rot = &rotationSignal{done: make(chan struct{})}
+	m.rotMu.Unlock()
+	sig.info = info
+	close(sig.done)
+}
+
+// Stage issues a new bundle and holds it without serving it, for two-phase
+// rotations: stage on every node, confirm, then Commit fleet-wide. Staging
+// again replaces the pending bundle. Scheduled rotations by Run continue
+// independently; if one lands a newer cert first, Commit discards the
+// staged bundle. A successful Stage does not count as recovery from failed
+// rotations until it is committed.
+func (m *Manager) Stage(ctx context.Context) (*Bundle, error) {
+	ctx = withRotationID(ctx)
+	bundle, err := m.issue(ctx)
 	if err != nil {
+		m.record(ctx, RotationFailed, nil, err)
 		return nil, err
 	}
-	return b.Cert, nil
+	m.pendMu.Lock()
+	m.pending, m.pendingID = bundle, RotationID(ctx)
+	m.pendMu.Unlock()
+	return bundle, nil
+}
+
+// Commit atomically promotes the bundle held by Stage to current and fires
+// OnRotate. It returns ErrNoPending if nothing is staged, and ErrStalePending,
+// discarding the staged bundle, if the current bundle expires later than it,
+// so a rotation by Run since Stage is never rolled back.
+func (m *Manager) Commit() error {
+	m.pendMu.Lock()
+	bundle, id := m.pending, m.pendingID
+	m.pending, m.pendingID = nil, ""
+	m.pendMu.Unlock()
+	if bundle == nil {
+		return ErrNoPending
+	}
+	var prev *Bundle
+	for {
+		old := m.curr.Load()
+		prev, _ = old.(*Bundle)
+		if prev != nil && bundle.NotAfter.Before(prev.NotAfter) {
+			return ErrStalePending
+		}
+		if m.curr.CompareAndSwap(old, bundle) {
+			break
+		}
+	}
+	m.stored(prev)
+	ctx := context.WithValue(context.Background(), rotationIDKey{}, id)
+	m.recovered(ctx, bundle)
+	m.rotated(ctx, prev, bundle)
+	return nil
 }
 
-func (m *Manager) refresh(ctx context.Context) (*Bundle, time.Time, error) {
-	bundle, err := m.issuer.Issue(ctx)
+func (m *Manager) refresh(ctx context.Context) (*Bundle, time.Time, bool, error) {
+	bundle, err := m.issue(ctx)
 	if err != nil {
-		return nil, time.Time{}, err
+		return nil, time.Time{}, false, err
+	}
+	m.recovered(ctx, bundle)
+
+	changed := true
+	if prev, _ := m.Current(); sameBundle(prev, bundle) {
+		m.opts.Logger.DebugContext(ctx, "certmanager: issuer returned unchanged bundle, skipping rotation",
+			"serial", leafCert(bundle).SerialNumber.String(), "rotation_id", RotationID(ctx))
+		bundle, changed = prev, false
+	} else {
+		m.store(bundle)
 	}
-	m.curr.Store(bundle)
 
 	now := m.opts.Now()
 	ttl := bundle.NotAfter.Sub(now)
-	return bundle, now.Add(ttl * 2 / 3), nil
+	if err := checkClock(leafCert(bundle), now); err != nil {
+		m.onError(ctx, err)
+		// Schedule from the cert's own lifetime so a clock far in the past
+		// does not postpone rotation for years.
+		if leaf := leafCert(bundle); ttl > leaf.NotAfter.Sub(leaf.NotBefore) {
+			ttl = leaf.NotAfter.Sub(leaf.NotBefore)
+		}
+	}
+	if ttl <= m.opts.MinRefresh {
+		m.opts.Logger.WarnContext(ctx, "certmanager: cert lifetime is shorter than MinRefresh, rotating early",
+			"ttl", ttl, "min_refresh", m.opts.MinRefresh, "rotation_id", RotationID(ctx))
+	}
+	return bundle, now.Add(ttl * 2 / 3), changed, nil
+}
+
+// maxClockSkew is how far Now may fall outside a fresh leaf's validity
+// window before ErrClockSkew is reported.
+const maxClockSkew = 5 * time.Minute
+
+// checkClock reports ErrClockSkew if now is outside leaf's validity window by
+// more than maxClockSkew.
+func checkClock(leaf *x509.Certificate, now time.Time) error {
+	if leaf == nil {
+		return nil
+	}
+	if now.Before(leaf.NotBefore.Add(-maxClockSkew)) || now.After(leaf.NotAfter.Add(maxClockSkew)) {
+		return fmt.Errorf("%w: now is %s but the cert is valid %s to %s; check NTP",
+			ErrClockSkew, now.UTC().Format(time.RFC3339),
+			leaf.NotBefore.UTC().Format(time.RFC3339), leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
 }
 
-func (m *Manager) onRotate(bundle *Bundle) {
+// issue calls the issuer, offering RecoveryTTL after failed attempts. It
+// counts failures; callers that put the bundle into service report success
+// through recovered.
+func (m *Manager) issue(ctx context.Context) (*Bundle, error) {
+	if m.opts.RecoveryTTL > 0 && m.failures.Load() > 0 {
+		ctx = context.WithValue(ctx, recoveryTTLKey{}, m.opts.RecoveryTTL)
+	}
+	ictx, cancel, margin := m.issueContext(ctx)
+	defer cancel()
+	bundle, err := m.issuer.Issue(ictx)
+	if err == nil && bundle == nil {
+		err = ErrNilBundle
+	}
+	if err != nil {
+		m.failures.Add(1)
+		if ctx.Err() == nil && errors.Is(ictx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("issuance abandoned %s before the current cert expires: %w", margin, err)
+		}
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// recovered resets the failure count now that bundle is in service, firing
+// OnRecovered if issuance had been failing.
+func (m *Manager) recovered(ctx context.Context, bundle *Bundle) {
+	if m.failures.Swap(0) > 0 {
+		m.onRecovered(ctx, bundle)
+	}
+}
+
+// issueContext bounds ctx so a stuck issuance is abandoned shortly before
+// the current cert expires, leaving time to report the failure and retry,
+// instead of blocking past expiry on the issuer's own timeouts. The margin
+// is a tenth of the remaining validity, at most a minute. Without an
+// unexpired current bundle, ctx is returned unbounded.
+func (m *Manager) issueContext(ctx context.Context) (context.Context, context.CancelFunc, time.Duration) {
+	b, err := m.Current()
+	if err != nil {
+		return ctx, func() {}, 0
+	}
+	remaining := b.NotAfter.Sub(m.opts.Now())
+	if remaining <= 0 {
+		return ctx, func() {}, 0
+	}
+	margin := min(remaining/10, time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, remaining-margin)
+	return ctx, cancel, margin
+}
+
+// store swaps in bundle and signals Ready on the first one.
+func (m *Manager) store(bundle *Bundle) {
+	old, _ := m.curr.Swap(bundle).(*Bundle)
+	m.stored(old)
+}
+
+// stored follows a swap that replaced old: it retains old for Previous,
+// signals Ready and kicks OCSP stapling.
+func (m *Manager) stored(old *Bundle) {
+	if old != nil && m.opts.PreviousRetention > 0 {
+		m.prev.Store(&retainedBundle{bundle: old, expires: m.opts.Now().Add(m.opts.PreviousRetention)})
+	}
+	m.readyOnce.Do(func() { close(m.ready) })
+	select {
+	case m.ocspKick <- struct{}{}:
+	default:
+	}
+}
+
+// hookContext returns a context for a hook invocation that keeps parent's
+// values (e.g. the rotation ID) but not its cancellation, bounded by HookTimeout.
+// The manager is attached for FromContext.
+func (m *Manager) hookContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.WithoutCancel(parent), managerKey{}, m)
+	return context.WithTimeout(ctx, m.opts.HookTimeout)
+}
+
+func (m *Manager) onRotate(ctx context.Context, bundle *Bundle) {
 	if m.opts.OnRotate == nil || bundle == nil {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), m.opts.HookTimeout)
+	hctx, cancel := m.hookContext(ctx)
 	info := bundleInfo(bundle)
+	info.RotationID = RotationID(ctx)
 	go func() {
 		defer cancel()
-		m.opts.OnRotate(ctx, info)
+		m.opts.OnRotate(hctx, info)
 	}()
 }
 
-func (m *Manager) onError(err error) {
+func (m *Manager) onError(ctx context.Context, err error) {
 	if m.opts.OnError == nil || err == nil {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), m.opts.HookTimeout)
+	if err = m.coalesceError(err); err == nil {
+		return
+	}
+	hctx, cancel := m.hookContext(ctx)
 	go func() {
 		defer cancel()
-		m.opts.OnError(ctx, err)
+		m.opts.OnError(hctx, err)
+	}()
+}
+
+// coalesceError returns the error to report, or nil if it should be suppressed.
+func (m *Manager) coalesceError(err error) error {
+	if m.opts.ErrorHookInterval <= 0 {
+		return err
+	}
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+
+	now := m.opts.Now()
+	if !sameError(m.lastErr, err) {
+		m.lastErr, m.lastErrAt, m.suppressed = err, now, 0
+		return err
+	}
+	if now.Sub(m.lastErrAt) < m.opts.ErrorHookInterval {
+		m.suppressed++
+		return nil
+	}
+	suppressed := m.suppressed
+	m.lastErrAt, m.suppressed = now, 0
+	if suppressed == 0 {
+		return err
+	}
+	return &CoalescedError{Err: err, Suppressed: suppressed}
+}
+
+func (m *Manager) resetErrors() {
+	m.errMu.Lock()
+	m.lastErr, m.suppressed = nil, 0
+	m.errMu.Unlock()
+}
+
+func sameError(a, b error) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return errors.Is(b, a) || errors.Is(a, b) || a.Error() == b.Error()
+}
+
+func (m *Manager) onRecovered(ctx context.Context, bundle *Bundle) {
+	if m.opts.OnRecovered == nil {
+		return
+	}
+	hctx, cancel := m.hookContext(ctx)
+	info := bundleInfo(bundle)
+	info.RotationID = RotationID(ctx)
+	go func() {
+		defer cancel()
+		m.opts.OnRecovered(hctx, info)
+	}()
+}
+
+func (m *Manager) onCAChange(ctx context.Context, fingerprints []string) {
+	if m.opts.OnCAChange == nil {
+		return
+	}
+	hctx, cancel := m.hookContext(ctx)
+	go func() {
+		defer cancel()
+		m.opts.OnCAChange(hctx, fingerprints)
 	}()
 }
 
@@ -181,19 +935,39 @@ func (m *Manager) sleep(ctx context.Context, d time.Duration) bool {
 	}
 }
 
-func bundleInfo(bundle *Bundle) BundleInfo {
-	info := BundleInfo{
-		NotAfter: bundle.NotAfter,
+// sameBundle reports whether a and b carry the same leaf serial number.
+func sameBundle(a, b *Bundle) bool {
+	if a == nil || b == nil {
+		return false
 	}
-	if bundle.Cert == nil {
-		return info
+	la, lb := leafCert(a), leafCert(b)
+	if la == nil || lb == nil || la.SerialNumber == nil || lb.SerialNumber == nil {
+		return false
+	}
+	return la.SerialNumber.Cmp(lb.SerialNumber) == 0
+}
+
+func leafCert(bundle *Bundle) *x509.Certificate {
+	if bundle == nil || bundle.Cert == nil {
+		return nil
+	}
+	if bundle.Cert.Leaf != nil {
+		return bundle.Cert.Leaf
 	}
-	leaf := bundle.Cert.Leaf
-	if leaf == nil && len(bundle.Cert.Certificate) > 0 {
+	if len(bundle.Cert.Certificate) > 0 {
 		if parsed, err := x509.ParseCertificate(bundle.Cert.Certificate[0]); err == nil {
-			leaf = parsed
+			return parsed
 		}
 	}
+	return nil
+}
+
+func bundleInfo(bundle *Bundle) BundleInfo {
+	info := BundleInfo{
+		NotAfter:       bundle.NotAfter,
+		CAFingerprints: caFingerprints(bundle),
+	}
+	leaf := leafCert(bundle)
 	if leaf == nil {
 		return info
 	}
@@ -211,3 +985,26 @@ func bundleInfo(bundle *Bundle) BundleInfo {
 	}
 	return info
 }
+
+// caFingerprints returns the sorted, de-duplicated hex SHA-256 fingerprints
+// of the bundle's CA certs.
+func caFingerprints(bundle *Bundle) []string {
+	if bundle == nil || len(bundle.CACerts) == 0 {
+		return nil
+	}
+	fps := make([]string, 0, len(bundle.CACerts))
+	for _, cert := range bundle.CACerts {
+		if cert == nil {
+			continue
+		}
+		fps = append(fps, fingerprint(cert))
+	}
+	slices.Sort(fps)
+	return slices.Compact(fps)
+}
+
+// fingerprint returns the hex SHA-256 of the cert's DER encoding.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -2,11 +2,44 @@ package certmanager
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 )
 
+type sequenceIssuer struct {
+	bundles []*Bundle
+	calls   int32
+}
+
+func (s *sequenceIssuer) Issue(_ context.Context) (*Bundle, error) {
+	i := int(atomic.AddInt32(&s.calls, 1)) - 1
+	if i >= len(s.bundles) {
+		i = len(s.bundles) - 1
+	}
+	return s.bundles[i], nil
+}
+
+// flakyIssuer returns err when set, otherwise bundle.
+type flakyIssuer struct {
+	bundle *Bundle
+	err    error
+}
+
+func (f *flakyIssuer) Issue(_ context.Context) (*Bundle, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.bundle, nil
+}
+
 type staticIssuer struct {
 	bundle *Bundle
 	err    error
@@ -32,7 +65,7 @@ func TestRefreshSchedulesAtTwoThirdsTTL(t *testing.T) {
 		Now: func() time.Time { return now },
 	})
 
-	_, next, err := mgr.refresh(context.Background())
+	_, next, _, err := mgr.refresh(context.Background())
 	if err != nil {
 		t.Fatalf("refresh failed: %v", err)
 	}
@@ -42,6 +75,140 @@ func TestRefreshSchedulesAtTwoThirdsTTL(t *testing.T) {
 	}
 }
 
+func TestRefreshWaitFractionalFloor(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		ttl      time.Duration
+		min      time.Duration
+		fraction float64
+		want     time.Duration
+	}{
+		// 2/3 of 10s is below both floors; the absolute floor is larger.
+		{"short ttl absolute wins", 10 * time.Second, 8 * time.Second, 0.5, 8 * time.Second},
+		// 2/3 of 10s is below the fractional floor of 9s.
+		{"short ttl fraction wins", 10 * time.Second, time.Second, 0.9, 9 * time.Second},
+		// 2/3 of 24h is 16h, above a 0.5 fractional floor of 12h.
+		{"long ttl schedule wins", 24 * time.Hour, time.Minute, 0.5, 16 * time.Hour},
+		// A 0.75 fractional floor of 18h pushes past the 16h schedule.
+		{"long ttl fraction wins", 24 * time.Hour, time.Minute, 0.75, 18 * time.Hour},
+	}
+	for _, c := range cases {
+		bundle := &Bundle{NotAfter: now.Add(c.ttl)}
+		mgr := NewWithOptions(staticIssuer{bundle: bundle}, Options{
+			MinRefresh:         c.min,
+			MinRefreshFraction: c.fraction,
+			Now:                func() time.Time { return now },
+		})
+		_, next, _, err := mgr.refresh(context.Background())
+		if err != nil {
+			t.Fatalf("%s: refresh failed: %v", c.name, err)
+		}
+		if got := mgr.refreshWait(next); got != c.want {
+			t.Fatalf("%s: wait = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRefreshWaitShortLivedCert(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	bundle := &Bundle{NotAfter: now.Add(5 * time.Second)}
+	mgr := NewWithOptions(staticIssuer{bundle: bundle}, Options{
+		MinRefresh: 30 * time.Second,
+		Now:        func() time.Time { return now },
+	})
+
+	_, next, _, err := mgr.refresh(context.Background())
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	wait := mgr.refreshWait(next)
+	if wait <= 0 || wait >= 5*time.Second {
+		t.Fatalf("wait = %s, want within the 5s cert lifetime", wait)
+	}
+	if want := 5 * time.Second * 2 / 3; wait != want {
+		t.Fatalf("wait = %s, want %s", wait, want)
+	}
+}
+
+func TestTickSchedulesWithFakeClock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	fail := errors.New("vault down")
+	issuer := &flakyIssuer{bundle: &Bundle{NotAfter: now.Add(90 * time.Second)}}
+	mgr := NewWithOptions(issuer, Options{
+		MinRefresh:   time.Second,
+		ErrorBackoff: 7 * time.Second,
+		Now:          func() time.Time { return now },
+	})
+
+	jitter := func(wait time.Duration) time.Duration {
+		return time.Duration(now.UnixNano() % int64(wait/10+1))
+	}
+
+	wait, err := mgr.tick(context.Background())
+	if err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+	// 2/3 of 90s is 60s, plus up to 10% jitter derived from the clock.
+	if want := 60*time.Second + jitter(60*time.Second); wait != want {
+		t.Fatalf("wait = %s, want %s", wait, want)
+	}
+
+	now = now.Add(30 * time.Second)
+	issuer.err = fail
+	wait, err = mgr.tick(context.Background())
+	if !errors.Is(err, fail) {
+		t.Fatalf("tick error = %v, want %v", err, fail)
+	}
+	if wait != 7*time.Second {
+		t.Fatalf("error wait = %s, want ErrorBackoff 7s", wait)
+	}
+
+	issuer.err = nil
+	wait, err = mgr.tick(context.Background())
+	if err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+	// 60s of validity remain; 2/3 is 40s.
+	if want := 40*time.Second + jitter(40*time.Second); wait != want {
+		t.Fatalf("wait = %s, want %s", wait, want)
+	}
+}
+
+func TestJitterStaysOutOfSafetyMargin(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	const lifetime = 100 * time.Second
+	var jittered bool
+	for i := range 500 {
+		now := base.Add(time.Duration(i) * 7919 * time.Microsecond)
+		// An 85% floor leaves the full 10% jitter room to overshoot.
+		mgr := NewWithOptions(&flakyIssuer{bundle: &Bundle{NotAfter: now.Add(lifetime)}}, Options{
+			MinRefresh:         time.Second,
+			MinRefreshFraction: 0.85,
+			Now:                func() time.Time { return now },
+		})
+		wait, err := mgr.tick(context.Background())
+		if err != nil {
+			t.Fatalf("tick failed: %v", err)
+		}
+		if wait < 85*time.Second || wait > lifetime*9/10 {
+			t.Fatalf("wait = %s at %s, want within [85s, 90s]", wait, now)
+		}
+		jittered = jittered || wait > 85*time.Second
+	}
+	if !jittered {
+		t.Fatal("expected jitter to be applied when there is room")
+	}
+}
+
 func TestOnRotateTimeoutAsync(t *testing.T) {
 	t.Parallel()
 
@@ -59,7 +226,7 @@ func TestOnRotateTimeoutAsync(t *testing.T) {
 		},
 	})
 
-	mgr.onRotate(&Bundle{NotAfter: time.Now().Add(time.Minute)})
+	mgr.onRotate(context.Background(), &Bundle{NotAfter: time.Now().Add(time.Minute)})
 
 	select {
 	case <-ctxCh:
@@ -114,3 +281,924 @@ func TestRunIntegrationWithIssuer(t *testing.T) {
 		t.Fatalf("expected current bundle to be set: %v", err)
 	}
 }
+
+func TestRotateSkipsUnchangedSerial(t *testing.T) {
+	t.Parallel()
+
+	leaf := &x509.Certificate{SerialNumber: big.NewInt(42)}
+	issuer := staticIssuer{
+		bundle: &Bundle{
+			Cert:     &tls.Certificate{Leaf: leaf},
+			NotAfter: time.Now().Add(time.Hour),
+		},
+	}
+
+	var rotations int32
+	rotated := make(chan struct{}, 2)
+	mgr := NewWithOptions(issuer, Options{
+		OnRotate: func(context.Context, BundleInfo) {
+			atomic.AddInt32(&rotations, 1)
+			rotated <- struct{}{}
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := mgr.rotate(context.Background()); err != nil {
+			t.Fatalf("rotate failed: %v", err)
+		}
+	}
+
+	select {
+	case <-rotated:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("OnRotate was not invoked")
+	}
+	select {
+	case <-rotated:
+		t.Fatal("OnRotate fired for an unchanged serial")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := atomic.LoadInt32(&rotations); got != 1 {
+		t.Fatalf("expected 1 rotation, got %d", got)
+	}
+}
+
+func TestOnCAChangeFiresOnlyWhenCASetDiffers(t *testing.T) {
+	t.Parallel()
+
+	caA := &x509.Certificate{Raw: []byte("ca-a")}
+	caB := &x509.Certificate{Raw: []byte("ca-b")}
+	newBundle := func(serial int64, cas ...*x509.Certificate) *Bundle {
+		return &Bundle{
+			Cert:     &tls.Certificate{Leaf: &x509.Certificate{SerialNumber: big.NewInt(serial)}},
+			CACerts:  cas,
+			NotAfter: time.Now().Add(time.Hour),
+		}
+	}
+	issuer := &sequenceIssuer{bundles: []*Bundle{
+		newBundle(1, caA),
+		newBundle(2, caA),
+		newBundle(3, caB, caA),
+	}}
+
+	changes := make(chan []string, 3)
+	mgr := NewWithOptions(issuer, Options{
+		OnCAChange: func(_ context.Context, fps []string) {
+			changes <- fps
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := mgr.rotate(context.Background()); err != nil {
+			t.Fatalf("rotate failed: %v", err)
+		}
+	}
+
+	var got [][]string
+	timeout := time.After(100 * time.Millisecond)
+	for len(got) < 2 {
+		select {
+		case fps := <-changes:
+			got = append(got, fps)
+		case <-timeout:
+			t.Fatalf("expected 2 CA changes, got %d", len(got))
+		}
+	}
+	select {
+	case <-changes:
+		t.Fatal("OnCAChange fired for an unchanged CA set")
+	case <-time.After(20 * time.Millisecond):
+	}
+	// Hooks run asynchronously, so delivery order is not guaranteed.
+	if len(got[0])+len(got[1]) != 3 {
+		t.Fatalf("unexpected fingerprint sets: %v", got)
+	}
+}
+
+func TestOnErrorCoalescesIdenticalErrors(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	errs := make(chan error, 10)
+	mgr := NewWithOptions(staticIssuer{}, Options{
+		ErrorHookInterval: time.Minute,
+		Now:               func() time.Time { return now },
+		OnError: func(_ context.Context, err error) {
+			errs <- err
+		},
+	})
+
+	down := errors.New("vault http 503: sealed")
+	for i := 0; i < 4; i++ {
+		mgr.onError(context.Background(), down)
+		now = now.Add(15 * time.Second)
+	}
+	// 60s elapsed since the first report; the next one is let through.
+	mgr.onError(context.Background(), down)
+
+	var got []error
+	timeout := time.After(100 * time.Millisecond)
+	for len(got) < 2 {
+		select {
+		case err := <-errs:
+			got = append(got, err)
+		case <-timeout:
+			t.Fatalf("expected 2 OnError calls, got %d", len(got))
+		}
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected extra OnError call: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	var coalesced *CoalescedError
+	for _, err := range got {
+		if !errors.Is(err, down) {
+			t.Fatalf("expected reported error to wrap original: %v", err)
+		}
+		if errors.As(err, &coalesced) {
+			break
+		}
+	}
+	if coalesced == nil || coalesced.Suppressed != 3 {
+		t.Fatalf("expected CoalescedError with 3 suppressed, got %v", got)
+	}
+}
+
+func TestRefreshRejectsNilBundle(t *testing.T) {
+	t.Parallel()
+
+	good := &Bundle{NotAfter: time.Now().Add(time.Hour)}
+	issuer := &sequenceIssuer{bundles: []*Bundle{good, nil}}
+
+	errs := make(chan error, 1)
+	mgr := NewWithOptions(issuer, Options{
+		OnError: func(_ context.Context, err error) { errs <- err },
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mgr.Run(ctx)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrNilBundle) {
+			t.Fatalf("OnError got %v, want ErrNilBundle", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnError for nil bundle")
+	}
+
+	got, err := mgr.Current()
+	if err != nil {
+		t.Fatalf("expected previous bundle to be kept: %v", err)
+	}
+	if got != good {
+		t.Fatal("expected previous bundle to remain current")
+	}
+	if _, err := mgr.GetCertificate(nil); err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+}
+
+func TestStartRunsPreflight(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	sealed := errors.New("sealed")
+	mgr := NewWithOptions(staticIssuer{bundle: &Bundle{}, calls: &calls}, Options{
+		Preflight: func(context.Context) error { return sealed },
+	})
+	if err := mgr.Start(context.Background()); !errors.Is(err, sealed) {
+		t.Fatalf("Start() = %v, want preflight error", err)
+	}
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Fatal("expected issuer not to be called when preflight fails")
+	}
+}
+
+func TestOnCAExpiryWarning(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiring := &x509.Certificate{Raw: []byte("old-ca"), NotAfter: now.Add(30 * time.Second)}
+	healthy := &x509.Certificate{Raw: []byte("new-ca"), NotAfter: now.Add(365 * 24 * time.Hour)}
+	issuer := staticIssuer{bundle: &Bundle{
+		CACerts:  []*x509.Certificate{expiring, healthy},
+		NotAfter: now.Add(90 * time.Second),
+	}}
+
+	warnings := make(chan []CAExpiry, 1)
+	mgr := NewWithOptions(issuer, Options{
+		Now: func() time.Time { return now },
+		OnCAExpiryWarning: func(_ context.Context, cas []CAExpiry) {
+			warnings <- cas
+		},
+	})
+	if _, err := mgr.tick(context.Background()); err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+
+	select {
+	case cas := <-warnings:
+		if len(cas) != 1 || !cas[0].NotAfter.Equal(expiring.NotAfter) {
+			t.Fatalf("unexpected CA expiry warnings: %+v", cas)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("expected OnCAExpiryWarning for CA expiring before next rotation")
+	}
+}
+
+func TestStartInfoDescribesInitialBundle(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	bundle := ca.bundle(t, 7, testSpiffeID)
+	mgr := New(staticIssuer{bundle: bundle})
+
+	info, err := mgr.StartInfo(context.Background())
+	if err != nil {
+		t.Fatalf("StartInfo failed: %v", err)
+	}
+	if info.CommonName != "Test Leaf" || info.SerialNumber != "7" {
+		t.Fatalf("info = %+v, want CN Test Leaf serial 7", info)
+	}
+	if !info.NotAfter.Equal(bundle.NotAfter) {
+		t.Fatalf("NotAfter = %s, want %s", info.NotAfter, bundle.NotAfter)
+	}
+	if len(info.URIs) != 1 || info.URIs[0] != testSpiffeID {
+		t.Fatalf("URIs = %v, want [%s]", info.URIs, testSpiffeID)
+	}
+
+	failing := New(staticIssuer{err: errors.New("down")})
+	if _, err := failing.StartInfo(context.Background()); err == nil {
+		t.Fatal("expected StartInfo to surface issuance errors")
+	}
+}
+
+func TestBootstrapSelfSignedServesUntilFirstIssuance(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	bundle := ca.bundle(t, 9, testSpiffeID)
+	issuer := &flakyIssuer{err: errors.New("vault unreachable")}
+	mgr := NewWithOptions(issuer, Options{BootstrapSelfSigned: true})
+
+	if err := mgr.Start(context.Background()); err == nil {
+		t.Fatal("expected Start to fail while issuer is down")
+	}
+	if _, err := mgr.Current(); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("Current err = %v, want ErrNotReady", err)
+	}
+	cert, err := mgr.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.Subject.String() != cert.Leaf.Issuer.String() {
+		t.Fatalf("expected self-signed bootstrap cert, got %+v", cert.Leaf)
+	}
+	if client, err := mgr.GetClientCertificate(nil); err != nil || client != cert {
+		t.Fatalf("GetClientCertificate = %v, %v; want bootstrap cert", client, err)
+	}
+
+	issuer.err, issuer.bundle = nil, bundle
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	cert, err = mgr.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if cert != bundle.Cert {
+		t.Fatal("expected issued cert to replace the bootstrap cert")
+	}
+
+	plain := New(staticIssuer{err: errors.New("down")})
+	if _, err := plain.GetCertificate(&tls.ClientHelloInfo{}); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("GetCertificate err = %v, want ErrNotReady without bootstrap", err)
+	}
+}
+
+func TestReadyClosesOnFirstBundle(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	issuer := &sequenceIssuer{bundles: []*Bundle{ca.bundle(t, 1, testSpiffeID), ca.bundle(t, 2, testSpiffeID)}}
+	mgr := NewWithOptions(issuer, Options{MinRefresh: time.Millisecond})
+
+	select {
+	case <-mgr.Ready():
+		t.Fatal("Ready closed before any bundle was issued")
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mgr.Run(ctx)
+	}()
+
+	select {
+	case <-mgr.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for Ready")
+	}
+	if _, err := mgr.Current(); err != nil {
+		t.Fatalf("Current after Ready: %v", err)
+	}
+
+	// Later rotations must not close the channel again, and late
+	// subscribers still observe it closed.
+	for atomic.LoadInt32(&issuer.calls) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	select {
+	case <-mgr.Ready():
+	default:
+		t.Fatal("Ready not closed for late subscriber")
+	}
+}
+
+func TestRotateAndGetReturnsNewBundle(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	first, second := ca.bundle(t, 1, testSpiffeID), ca.bundle(t, 2, testSpiffeID)
+	rotated := make(chan BundleInfo, 2)
+	mgr := NewWithOptions(&sequenceIssuer{bundles: []*Bundle{first, second, second}}, Options{
+		OnRotate: func(_ context.Context, info BundleInfo) { rotated <- info },
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	got, err := mgr.RotateAndGet(context.Background())
+	if err != nil {
+		t.Fatalf("RotateAndGet failed: %v", err)
+	}
+	if got != second {
+		t.Fatal("expected RotateAndGet to return the newly issued bundle")
+	}
+	select {
+	case info := <-rotated:
+		if info.SerialNumber != "2" || info.RotationID == "" {
+			t.Fatalf("OnRotate info = %+v, want serial 2 with rotation ID", info)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for OnRotate")
+	}
+
+	got, err = mgr.RotateAndGet(context.Background())
+	if err != nil || got != second {
+		t.Fatalf("RotateAndGet = %v, %v; want unchanged current bundle", got, err)
+	}
+
+	failing := New(staticIssuer{err: errors.New("down")})
+	if err := failing.Rotate(context.Background()); err == nil {
+		t.Fatal("expected Rotate to return issuance errors")
+	}
+}
+
+func TestStageAndCommit(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	first, second := ca.bundle(t, 1, testSpiffeID), ca.bundle(t, 2, testSpiffeID)
+	rotated := make(chan BundleInfo, 1)
+	mgr := NewWithOptions(&sequenceIssuer{bundles: []*Bundle{first, second}}, Options{
+		OnRotate: func(_ context.Context, info BundleInfo) { rotated <- info },
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	staged, err := mgr.Stage(context.Background())
+	if err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+	if staged != second {
+		t.Fatal("expected Stage to return the newly issued bundle")
+	}
+	if cert, _ := mgr.GetCertificate(&tls.ClientHelloInfo{}); cert != first.Cert {
+		t.Fatal("expected the old cert to be served until Commit")
+	}
+	select {
+	case <-rotated:
+		t.Fatal("OnRotate fired before Commit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := mgr.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if cert, _ := mgr.GetCertificate(&tls.ClientHelloInfo{}); cert != second.Cert {
+		t.Fatal("expected the staged cert to be served after Commit")
+	}
+	select {
+	case info := <-rotated:
+		if info.SerialNumber != "2" || info.RotationID == "" {
+			t.Fatalf("OnRotate info = %+v, want serial 2 with rotation ID", info)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for OnRotate")
+	}
+
+	if err := mgr.Commit(); !errors.Is(err, ErrNoPending) {
+		t.Fatalf("second Commit err = %v, want ErrNoPending", err)
+	}
+}
+
+func TestCommitRefusesStaleStagedBundle(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	first, staged, newer := ca.bundle(t, 1, testSpiffeID), ca.bundle(t, 2, testSpiffeID), ca.bundle(t, 3, testSpiffeID)
+	newer.NotAfter = staged.NotAfter.Add(time.Minute)
+	mgr := New(&sequenceIssuer{bundles: []*Bundle{first, staged, newer}})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, err := mgr.Stage(context.Background()); err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+	// Run rotates to a newer cert before the fleet-wide Commit.
+	if err := mgr.Rotate(context.Background()); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	if err := mgr.Commit(); !errors.Is(err, ErrStalePending) {
+		t.Fatalf("Commit err = %v, want ErrStalePending", err)
+	}
+	if cur, _ := mgr.Current(); cur != newer {
+		t.Fatal("expected Commit not to roll back to the staged bundle")
+	}
+	if err := mgr.Commit(); !errors.Is(err, ErrNoPending) {
+		t.Fatalf("second Commit err = %v, want the stale bundle discarded", err)
+	}
+}
+
+func TestStageRecoversOnlyOnCommit(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	issuer := &flakyIssuer{bundle: ca.bundle(t, 1, testSpiffeID)}
+	recovered := make(chan BundleInfo, 2)
+	mgr := NewWithOptions(issuer, Options{
+		OnRecovered: func(_ context.Context, info BundleInfo) { recovered <- info },
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	issuer.err = errors.New("vault unavailable")
+	if err := mgr.Rotate(context.Background()); err == nil {
+		t.Fatal("expected Rotate to fail")
+	}
+
+	issuer.err, issuer.bundle = nil, ca.bundle(t, 2, testSpiffeID)
+	if _, err := mgr.Stage(context.Background()); err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+	select {
+	case info := <-recovered:
+		t.Fatalf("OnRecovered fired for an uncommitted bundle: %+v", info)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := mgr.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	select {
+	case info := <-recovered:
+		if info.SerialNumber != "2" {
+			t.Fatalf("OnRecovered info = %+v, want the committed bundle", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnRecovered on Commit")
+	}
+}
+
+func TestStartAsyncReturnsBeforeFirstIssuance(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	release := make(chan struct{})
+	issuer := &gatedIssuer{release: release, bundle: ca.bundle(t, 1, testSpiffeID)}
+	mgr := New(issuer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := mgr.StartAsync(ctx)
+	if _, err := mgr.GetCertificate(&tls.ClientHelloInfo{}); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("GetCertificate err = %v, want ErrNotReady before first issuance", err)
+	}
+
+	close(release)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for Ready")
+	}
+	if _, err := mgr.Current(); err != nil {
+		t.Fatalf("Current after Ready: %v", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+// gatedIssuer blocks until release is closed.
+type gatedIssuer struct {
+	release chan struct{}
+	bundle  *Bundle
+}
+
+func (g *gatedIssuer) Issue(ctx context.Context) (*Bundle, error) {
+	select {
+	case <-g.release:
+		return g.bundle, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestNextRotation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	issuer := &flakyIssuer{bundle: &Bundle{NotAfter: now.Add(30 * time.Minute)}}
+	mgr := NewWithOptions(issuer, Options{
+		Now:          func() time.Time { return now },
+		ErrorBackoff: time.Minute,
+	})
+	if _, ok := mgr.NextRotation(); ok {
+		t.Fatal("expected no scheduled rotation before Run")
+	}
+
+	wait, err := mgr.tick(context.Background())
+	if err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+	next, ok := mgr.NextRotation()
+	if !ok || !next.Equal(now.Add(wait)) {
+		t.Fatalf("NextRotation() = %v, %v; want %v", next, ok, now.Add(wait))
+	}
+	if wait < 20*time.Minute || wait > 22*time.Minute {
+		t.Fatalf("wait = %v, want about 2/3 of the 30m lifetime", wait)
+	}
+
+	issuer.err = errors.New("vault down")
+	if _, err := mgr.tick(context.Background()); err == nil {
+		t.Fatal("expected tick to fail")
+	}
+	if next, _ := mgr.NextRotation(); !next.Equal(now.Add(time.Minute)) {
+		t.Fatalf("NextRotation() = %v, want the error backoff retry", next)
+	}
+}
+
+func TestClientIssuerServesClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	serverBundle := ca.bundle(t, 1, "spiffe://corp/prod/svc/server")
+	clientBundle := ca.bundle(t, 2, "spiffe://corp/prod/svc/client")
+	mgr := NewWithOptions(staticIssuer{bundle: serverBundle}, Options{
+		ClientIssuer: staticIssuer{bundle: clientBundle},
+	})
+	if _, err := mgr.GetClientCertificate(nil); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("GetClientCertificate err = %v, want ErrNotReady before Start", err)
+	}
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	got, err := mgr.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || got != serverBundle.Cert {
+		t.Fatalf("GetCertificate() = %v, %v; want the server bundle", got, err)
+	}
+	got, err = mgr.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil || got != clientBundle.Cert {
+		t.Fatalf("GetClientCertificate() = %v, %v; want the client bundle", got, err)
+	}
+
+	down := errors.New("client role missing")
+	failing := NewWithOptions(staticIssuer{bundle: serverBundle}, Options{ClientIssuer: staticIssuer{err: down}})
+	if err := failing.Start(context.Background()); !errors.Is(err, down) {
+		t.Fatalf("Start() = %v, want client issuer error", err)
+	}
+}
+
+func TestClockSkewReportedAndBounded(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	bundle := ca.bundle(t, 1, testSpiffeID)
+	bundle.NotAfter = bundle.Cert.Leaf.NotAfter
+	errs := make(chan error, 4)
+	mgr := NewWithOptions(staticIssuer{bundle: bundle}, Options{
+		Now:     func() time.Time { return time.Unix(0, 0) },
+		OnError: func(_ context.Context, err error) { errs <- err },
+	})
+
+	wait, err := mgr.tick(context.Background())
+	if err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrClockSkew) {
+			t.Fatalf("OnError got %v, want ErrClockSkew", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnError to report clock skew")
+	}
+	if wait > time.Hour {
+		t.Fatalf("wait = %v, want it bounded by the cert's lifetime", wait)
+	}
+	if _, err := mgr.Current(); err != nil {
+		t.Fatalf("expected the bundle to be served despite skew: %v", err)
+	}
+}
+
+func TestWaitForRotation(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	first, second := ca.bundle(t, 1, testSpiffeID), ca.bundle(t, 2, testSpiffeID)
+	mgr := New(&sequenceIssuer{bundles: []*Bundle{first, first, second}})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	got := make(chan BundleInfo, 1)
+	go func() {
+		info, err := mgr.WaitForRotation(context.Background())
+		if err != nil {
+			t.Errorf("WaitForRotation failed: %v", err)
+		}
+		got <- info
+	}()
+
+	// The unchanged bundle must not wake the waiter.
+	if err := mgr.Rotate(context.Background()); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	select {
+	case info := <-got:
+		t.Fatalf("woke on an unchanged bundle: %+v", info)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := mgr.Rotate(context.Background()); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	select {
+	case info := <-got:
+		if info.SerialNumber != "2" || info.RotationID == "" {
+			t.Fatalf("WaitForRotation() = %+v, want serial 2 with a rotation ID", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for WaitForRotation")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := mgr.WaitForRotation(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForRotation() = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRotateIfOlderThan(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	issuer := &sequenceIssuer{bundles: []*Bundle{ca.bundle(t, 1, testSpiffeID), ca.bundle(t, 2, testSpiffeID)}}
+	mgr := New(issuer)
+
+	// Nothing stored yet: always issue.
+	if err := mgr.RotateIfOlderThan(context.Background(), time.Hour); err != nil {
+		t.Fatalf("RotateIfOlderThan failed: %v", err)
+	}
+	// The leaf's NotBefore is a minute ago.
+	if err := mgr.RotateIfOlderThan(context.Background(), time.Hour); err != nil {
+		t.Fatalf("RotateIfOlderThan failed: %v", err)
+	}
+	if calls := atomic.LoadInt32(&issuer.calls); calls != 1 {
+		t.Fatalf("issuer calls = %d, want a fresh cert left alone", calls)
+	}
+
+	if err := mgr.RotateIfOlderThan(context.Background(), 30*time.Second); err != nil {
+		t.Fatalf("RotateIfOlderThan failed: %v", err)
+	}
+	if b, _ := mgr.Current(); b.Cert.Leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("serial = %d, want the old cert replaced", b.Cert.Leaf.SerialNumber.Int64())
+	}
+}
+
+// blockingIssuer returns bundle on its first call and then blocks until the
+// context ends.
+type blockingIssuer struct {
+	bundle *Bundle
+	calls  atomic.Int32
+}
+
+func (b *blockingIssuer) Issue(ctx context.Context) (*Bundle, error) {
+	if b.calls.Add(1) == 1 {
+		return b.bundle, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestIssueAbandonedBeforeExpiry(t *testing.T) {
+	t.Parallel()
+
+	expiring := &Bundle{NotAfter: time.Now().Add(500 * time.Millisecond)}
+	errs := make(chan error, 1)
+	mgr := NewWithOptions(&blockingIssuer{bundle: expiring}, Options{
+		OnError: func(_ context.Context, err error) { errs <- err },
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	start := time.Now()
+	_, err := mgr.tick(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "abandoned") {
+		t.Fatalf("tick() = %v, want an abandoned issuance", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("issuance ran %s, past the cert's expiry", elapsed)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("OnError got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected OnError to fire")
+	}
+}
+
+// unreachableIssuer fails with err for the first failures calls.
+type unreachableIssuer struct {
+	bundle   *Bundle
+	err      error
+	failures int32
+	calls    atomic.Int32
+}
+
+func (u *unreachableIssuer) Issue(_ context.Context) (*Bundle, error) {
+	if u.calls.Add(1) <= u.failures {
+		return nil, u.err
+	}
+	return u.bundle, nil
+}
+
+func TestStartRetriesConnectionErrors(t *testing.T) {
+	t.Parallel()
+
+	bundle := newTestCA(t).bundle(t, 1, testSpiffeID)
+	dnsErr := &url.Error{Op: "Post", URL: "https://vault:8200", Err: &net.DNSError{Err: "no such host", Name: "vault", IsNotFound: true}}
+
+	issuer := &unreachableIssuer{bundle: bundle, err: dnsErr, failures: 2}
+	mgr := NewWithOptions(issuer, Options{StartRetries: 3, StartRetryBackoff: time.Millisecond})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if calls := issuer.calls.Load(); calls != 3 {
+		t.Fatalf("issuer calls = %d, want 3", calls)
+	}
+
+	issuer = &unreachableIssuer{bundle: bundle, err: dnsErr, failures: 5}
+	mgr = NewWithOptions(issuer, Options{StartRetries: 1, StartRetryBackoff: time.Millisecond})
+	var dns *net.DNSError
+	if err := mgr.Start(context.Background()); !errors.As(err, &dns) {
+		t.Fatalf("Start() = %v, want the last DNS error", err)
+	}
+	if calls := issuer.calls.Load(); calls != 2 {
+		t.Fatalf("issuer calls = %d, want the first attempt plus 1 retry", calls)
+	}
+
+	denied := errors.New("vault http 403: permission denied")
+	issuer = &unreachableIssuer{bundle: bundle, err: denied, failures: 5}
+	mgr = NewWithOptions(issuer, Options{StartRetries: 3, StartRetryBackoff: time.Millisecond})
+	if err := mgr.Start(context.Background()); !errors.Is(err, denied) || issuer.calls.Load() != 1 {
+		t.Fatalf("Start() = %v after %d calls, want no retry of a non-connection error", err, issuer.calls.Load())
+	}
+
+	issuer = &unreachableIssuer{bundle: bundle, err: dnsErr, failures: 5}
+	mgr = NewWithOptions(issuer, Options{StartRetries: 3, StartRetryBackoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := mgr.Start(ctx); !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &dns) {
+		t.Fatalf("Start() = %v, want the DNS error and the context error", err)
+	}
+}
+
+func TestTimeToExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	mgr := NewWithOptions(&flakyIssuer{bundle: &Bundle{NotAfter: now.Add(time.Hour)}}, Options{
+		Now: func() time.Time { return now },
+	})
+	if _, ok := mgr.TimeToExpiry(); ok {
+		t.Fatal("expected TimeToExpiry to report false before the first bundle")
+	}
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if ttl, ok := mgr.TimeToExpiry(); !ok || ttl != time.Hour {
+		t.Fatalf("TimeToExpiry() = %s, %v; want 1h by Options.Now", ttl, ok)
+	}
+	now = now.Add(2 * time.Hour)
+	if ttl, _ := mgr.TimeToExpiry(); ttl != -time.Hour {
+		t.Fatalf("TimeToExpiry() = %s, want -1h after expiry", ttl)
+	}
+}
+
+func TestPreviousRetention(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	first, second := ca.bundle(t, 1, testSpiffeID), ca.bundle(t, 2, testSpiffeID)
+	now := time.Now()
+	mgr := NewWithOptions(&sequenceIssuer{bundles: []*Bundle{first, second}}, Options{
+		Now:               func() time.Time { return now },
+		PreviousRetention: time.Minute,
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, ok := mgr.Previous(); ok {
+		t.Fatal("expected no previous bundle before the first rotation")
+	}
+	if err := mgr.Rotate(context.Background()); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if prev, ok := mgr.Previous(); !ok || prev != first {
+		t.Fatalf("Previous() = %v, %v; want the replaced bundle", prev, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if prev, ok := mgr.Previous(); ok || prev != nil {
+		t.Fatalf("Previous() = %v, %v; want it cleared after the retention window", prev, ok)
+	}
+	if mgr.prev.Load() != nil {
+		t.Fatal("expected the previous bundle to be released after the retention window")
+	}
+}
+
+func TestOnRecovered(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	issuer := &flakyIssuer{bundle: ca.bundle(t, 1, testSpiffeID)}
+	recovered := make(chan BundleInfo, 4)
+	mgr := NewWithOptions(issuer, Options{
+		OnRecovered: func(_ context.Context, info BundleInfo) { recovered <- info },
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	issuer.err = errors.New("vault unavailable")
+	for range 2 {
+		if err := mgr.Rotate(context.Background()); err == nil {
+			t.Fatal("expected Rotate to fail")
+		}
+	}
+	issuer.err = nil
+	issuer.bundle = ca.bundle(t, 2, testSpiffeID)
+	if err := mgr.Rotate(context.Background()); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	select {
+	case info := <-recovered:
+		if info.SerialNumber != "2" || info.RotationID == "" {
+			t.Fatalf("OnRecovered info = %+v, want the recovering bundle and rotation ID", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnRecovered after a failure followed by a success")
+	}
+
+	// Healthy rotations do not fire it again.
+	if err := mgr.Rotate(context.Background()); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	select {
+	case info := <-recovered:
+		t.Fatalf("unexpected OnRecovered without a preceding failure: %+v", info)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
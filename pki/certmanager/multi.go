@@ -0,0 +1,115 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MultiIssuer issues from every issuer on each call, for active/active CAs
+// that are equally authoritative. Each issuer owns a slot that only its own
+// successful issuance updates; a failing issuer keeps its previous bundle
+// until that expires. The returned bundle presents the slot cert with the
+// latest NotAfter and trusts the CACerts of every live slot, so peers holding
+// a cert from either CA keep verifying. Issuers must populate Bundle.CACerts
+// for their CAs to enter the unified pool. Use it by pointer.
+//
+// If every issuer fails, Issue returns their joined errors even while older
+// slots are still live, so the Manager keeps serving its current bundle and
+// reports the outage through OnError. Failures of only some issuers are
+// passed to OnPartialFailure instead.
+type MultiIssuer struct {
+	Issuers []Issuer
+	// OnPartialFailure, if set, is called with the joined errors when some
+	// issuers failed but Issue still returns a bundle, e.g. to alert on a
+	// degraded CA. It runs synchronously and must not block.
+	OnPartialFailure func(context.Context, error)
+
+	mu    sync.Mutex
+	slots []*Bundle
+}
+
+func (m *MultiIssuer) Issue(ctx context.Context) (*Bundle, error) {
+	if len(m.Issuers) == 0 {
+		return nil, errors.New("multi issuer requires at least one issuer")
+	}
+	results := make([]*Bundle, len(m.Issuers))
+	errs := make([]error, len(m.Issuers))
+	var wg sync.WaitGroup
+	for i, issuer := range m.Issuers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bundle, err := issuer.Issue(ctx)
+			if err == nil && (bundle == nil || bundle.Cert == nil) {
+				err = ErrNilBundle
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("issuer %d: %w", i, err)
+				return
+			}
+			results[i] = bundle
+		}()
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.slots) != len(m.Issuers) {
+		m.slots = make([]*Bundle, len(m.Issuers))
+	}
+	now := time.Now()
+	var best *Bundle
+	var caCerts []*x509.Certificate
+	seen := make(map[string]struct{})
+	for i, bundle := range results {
+		if bundle != nil {
+			m.slots[i] = bundle
+		}
+		slot := m.slots[i]
+		if slot == nil || !slot.NotAfter.After(now) {
+			m.slots[i] = nil
+			continue
+		}
+		if best == nil || slot.NotAfter.After(best.NotAfter) {
+			best = slot
+		}
+		for _, cert := range slot.CACerts {
+			if _, ok := seen[string(cert.Raw)]; ok {
+				continue
+			}
+			seen[string(cert.Raw)] = struct{}{}
+			caCerts = append(caCerts, cert)
+		}
+	}
+	err := errors.Join(errs...)
+	if best == nil || allFailed(results) {
+		return nil, err
+	}
+	if err != nil && m.OnPartialFailure != nil {
+		m.OnPartialFailure(ctx, err)
+	}
+
+	pool := x509.NewCertPool()
+	for _, cert := range caCerts {
+		pool.AddCert(cert)
+	}
+	return &Bundle{
+		Cert:     best.Cert,
+		CA:       pool,
+		CACerts:  caCerts,
+		NotAfter: best.NotAfter,
+	}, nil
+}
+
+func allFailed(results []*Bundle) bool {
+	for _, b := range results {
+		if b != nil {
+			return false
+		}
+	}
+	return true
+}
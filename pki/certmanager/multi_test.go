@@ -0,0 +1,99 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiIssuerServesFreshestAndUnifiesTrust(t *testing.T) {
+	t.Parallel()
+
+	caA, caB := newTestCA(t), newTestCA(t)
+	bundleA := caA.bundle(t, 1, testSpiffeID)
+	bundleA.CACerts = []*x509.Certificate{caA.cert}
+	bundleA.NotAfter = time.Now().Add(time.Hour)
+	bundleB := caB.bundle(t, 2, testSpiffeID)
+	bundleB.CACerts = []*x509.Certificate{caB.cert}
+	bundleB.NotAfter = time.Now().Add(2 * time.Hour)
+
+	issuerA := &flakyIssuer{bundle: bundleA}
+	issuerB := &flakyIssuer{bundle: bundleB}
+	multi := &MultiIssuer{Issuers: []Issuer{issuerA, issuerB}}
+
+	got, err := multi.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got.Cert != bundleB.Cert || !got.NotAfter.Equal(bundleB.NotAfter) {
+		t.Fatal("expected the bundle with the latest NotAfter")
+	}
+	if len(got.CACerts) != 2 {
+		t.Fatalf("got %d CA certs, want both CAs", len(got.CACerts))
+	}
+	for _, b := range []*Bundle{bundleA, bundleB} {
+		if _, err := b.Cert.Leaf.Verify(x509.VerifyOptions{Roots: got.CA}); err != nil {
+			t.Fatalf("leaf from %s does not verify against unified pool: %v", b.CACerts[0].Subject, err)
+		}
+	}
+
+	// B fails on the next rotation while A issues a fresher cert: A's slot
+	// updates and B's previous bundle stays trusted.
+	fresher := caA.bundle(t, 3, testSpiffeID)
+	fresher.CACerts = []*x509.Certificate{caA.cert}
+	fresher.NotAfter = time.Now().Add(3 * time.Hour)
+	issuerA.bundle = fresher
+	issuerB.err = errors.New("vault b down")
+	var partial error
+	multi.OnPartialFailure = func(_ context.Context, err error) { partial = err }
+	got, err = multi.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed with one issuer down: %v", err)
+	}
+	if got.Cert != fresher.Cert {
+		t.Fatal("expected A's fresher bundle after it rotated")
+	}
+	if len(got.CACerts) != 2 {
+		t.Fatalf("got %d CA certs, want B's CA kept from its slot", len(got.CACerts))
+	}
+	if !errors.Is(partial, issuerB.err) {
+		t.Fatalf("OnPartialFailure got %v, want B's error", partial)
+	}
+}
+
+func TestMultiIssuerAllFail(t *testing.T) {
+	t.Parallel()
+
+	errA, errB := errors.New("a down"), errors.New("b down")
+	multi := &MultiIssuer{Issuers: []Issuer{&flakyIssuer{err: errA}, &flakyIssuer{err: errB}}}
+	_, err := multi.Issue(context.Background())
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Fatalf("Issue() = %v, want both errors", err)
+	}
+}
+
+func TestMultiIssuerAllFailWithLiveSlot(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	issuer := &flakyIssuer{bundle: ca.bundle(t, 1, testSpiffeID)}
+	multi := &MultiIssuer{Issuers: []Issuer{issuer}}
+	if _, err := multi.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	// The slot is still live, but a total outage must not look like an
+	// unchanged bundle.
+	issuer.err = errors.New("vault down")
+	got, err := multi.Issue(context.Background())
+	if !errors.Is(err, issuer.err) || got != nil {
+		t.Fatalf("Issue() = %v, %v; want the outage reported", got, err)
+	}
+
+	issuer.err = nil
+	if _, err := multi.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed after recovery: %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package certmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ocspFallbackRefresh is how long a staple without nextUpdate is served
+// before it is fetched again.
+const ocspFallbackRefresh = time.Hour
+
+// maxOCSPResponse bounds the size of an OCSP response body.
+const maxOCSPResponse = 1 << 20
+
+var (
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+
+	ocspHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// ocspSignatureAlgorithms maps the signature OIDs OCSP responders commonly
+// use. RSA-PSS is not supported.
+var ocspSignatureAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	algo x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	RequestList []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	CertID ocspCertID
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	KeyHash       []byte
+	SerialNumber  *big.Int
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	Type     asn1.ObjectIdentifier
+	Response []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw         asn1.RawContent
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// ocspStaple is a served cert carrying a staple for the bundle cert base,
+// valid until nextUpdate if it is set.
+type ocspStaple struct {
+	base       *tls.Certificate
+	cert       *tls.Certificate
+	nextUpdate time.Time
+}
+
+// staplingEnabled reports whether Run should fetch OCSP staples.
+func (m *Manager) staplingEnabled() bool {
+	return m.opts.StapleOCSP || m.opts.OCSPResponderURL != ""
+}
+
+// stapled returns cert with its current OCSP staple, if one was fetched and
+// has not expired, e.g. because refetching it keeps failing.
+func (m *Manager) stapled(cert *tls.Certificate) *tls.Certificate {
+	s := m.staple.Load()
+	if s == nil || s.base != cert {
+		return cert
+	}
+	if !s.nextUpdate.IsZero() && !m.opts.Now().Before(s.nextUpdate) {
+		return cert
+	}
+	return s.cert
+}
+
+// runOCSP keeps the current leaf's OCSP staple fresh until ctx is canceled,
+// refetching after every rotation and halfway to the staple's nextUpdate.
+func (m *Manager) runOCSP(ctx context.Context) {
+	select {
+	case <-m.ready:
+	case <-ctx.Done():
+		return
+	}
+	for {
+		wait := m.opts.ErrorBackoff
+		if b, err := m.Current(); err == nil {
+			refresh, err := m.refreshStaple(ctx, b)
+			if err != nil {
+				m.onError(ctx, fmt.Errorf("ocsp staple: %w", err))
+			} else {
+				wait = max(refresh.Sub(m.opts.Now()), m.opts.MinRefresh)
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-m.ocspKick:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// refreshStaple fetches and stores a staple for b and returns when to
+// refetch it.
+func (m *Manager) refreshStaple(ctx context.Context, b *Bundle) (time.Time, error) {
+	leaf := leafCert(b)
+	if leaf == nil {
+		return time.Time{}, errors.New("bundle leaf could not be parsed")
+	}
+	issuer := issuerOf(leaf, b)
+	if issuer == nil {
+		return time.Time{}, errors.New("issuer of leaf not found in bundle")
+	}
+	url := m.opts.OCSPResponderURL
+	if url == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return time.Time{}, errors.New("leaf has no OCSP responder and OCSPResponderURL is unset")
+		}
+		url = leaf.OCSPServer[0]
+	}
+	req, err := newOCSPRequest(leaf, issuer)
+	if err != nil {
+		return time.Time{}, err
+	}
+	staple, nextUpdate, err := fetchOCSP(ctx, url, req, leaf, issuer, m.opts.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	cert := *b.Cert
+	cert.OCSPStaple = staple
+	m.staple.Store(&ocspStaple{base: b.Cert, cert: &cert, nextUpdate: nextUpdate})
+
+	now := m.opts.Now()
+	if nextUpdate.IsZero() {
+		return now.Add(ocspFallbackRefresh), nil
+	}
+	return now.Add(nextUpdate.Sub(now) / 2), nil
+}
+
+// issuerOf returns the cert in b that signed leaf.
+func issuerOf(leaf *x509.Certificate, b *Bundle) *x509.Certificate {
+	var candidates []*x509.Certificate
+	for _, der := range b.Cert.Certificate[1:] {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			candidates = append(candidates, cert)
+		}
+	}
+	candidates = append(candidates, b.CACerts...)
+	for _, cert := range candidates {
+		if bytes.Equal(cert.RawSubject, leaf.RawIssuer) && leaf.CheckSignatureFrom(cert) == nil {
+			return cert
+		}
+	}
+	return nil
+}
+
+// newOCSPRequest builds a DER OCSP request for leaf (RFC 6960).
+func newOCSPRequest(leaf, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newOCSPCertID(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{
+		TBSRequest: ocspTBSRequest{RequestList: []ocspSingleRequest{{CertID: id}}},
+	})
+}
+
+func newOCSPCertID(leaf, issuer *x509.Certificate) (ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, fmt.Errorf("parse issuer public key: %w", err)
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		KeyHash:       keyHash[:],
+		SerialNumber:  leaf.SerialNumber,
+	}, nil
+}
+
+// fetchOCSP posts req to url and returns the response if it is signed by
+// issuer (or a responder it delegated to), reports leaf as good and has not
+// expired at now.
+func fetchOCSP(ctx context.Context, url string, req []byte, leaf, issuer *x509.Certificate, now time.Time) ([]byte, time.Time, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(req))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := ocspHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("responder %s: http %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponse))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("responder %s: read body: %w", url, err)
+	}
+	nextUpdate, err := checkOCSPResponse(body, leaf, issuer, now)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("responder %s: %w", url, err)
+	}
+	return body, nextUpdate, nil
+}
+
+// checkOCSPResponse verifies der and returns the nextUpdate of leaf's good
+// status, which is zero if the responder did not set one. A status whose
+// nextUpdate is before now is rejected: clients may hard-fail on an
+// expired staple.
+func checkOCSPResponse(der []byte, leaf, issuer *x509.Certificate, now time.Time) (time.Time, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return time.Time{}, fmt.Errorf("decode response: %w", err)
+	}
+	if resp.Status != 0 {
+		return time.Time{}, fmt.Errorf("response status %d", resp.Status)
+	}
+	if !resp.Response.Type.Equal(oidOCSPBasic) {
+		return time.Time{}, fmt.Errorf("unsupported response type %s", resp.Response.Type)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return time.Time{}, fmt.Errorf("decode basic response: %w", err)
+	}
+	if err := checkOCSPSignature(basic, issuer); err != nil {
+		return time.Time{}, err
+	}
+	id, err := newOCSPCertID(leaf, issuer)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, single := range basic.TBSResponseData.Responses {
+		if single.CertID.SerialNumber == nil || single.CertID.SerialNumber.Cmp(id.SerialNumber) != 0 ||
+			!bytes.Equal(single.CertID.NameHash, id.NameHash) || !bytes.Equal(single.CertID.KeyHash, id.KeyHash) {
+			continue
+		}
+		switch {
+		case bool(single.Good):
+			if !single.NextUpdate.IsZero() && single.NextUpdate.Before(now) {
+				return time.Time{}, fmt.Errorf("response expired at %s", single.NextUpdate)
+			}
+			return single.NextUpdate, nil
+		case bool(single.Unknown):
+			return time.Time{}, errors.New("responder does not know the leaf")
+		default:
+			return time.Time{}, fmt.Errorf("leaf revoked at %s", single.Revoked.RevocationTime)
+		}
+	}
+	return time.Time{}, errors.New("response does not cover the leaf")
+}
+
+// checkOCSPSignature verifies basic is signed by issuer or by an embedded
+// responder cert that issuer authorized for OCSP signing.
+func checkOCSPSignature(basic ocspBasicResponse, issuer *x509.Certificate) error {
+	algo := x509.UnknownSignatureAlgorithm
+	for _, a := range ocspSignatureAlgorithms {
+		if a.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algo = a.algo
+		}
+	}
+	if algo == x509.UnknownSignatureAlgorithm {
+		return fmt.Errorf("unsupported response signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	tbs, sig := basic.TBSResponseData.Raw, basic.Signature.RightAlign()
+	if issuer.CheckSignature(algo, tbs, sig) == nil {
+		return nil
+	}
+	for _, raw := range basic.Certificates {
+		responder, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil || responder.CheckSignatureFrom(issuer) != nil ||
+			!slices.Contains(responder.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning) {
+			continue
+		}
+		if responder.CheckSignature(algo, tbs, sig) == nil {
+			return nil
+		}
+	}
+	return errors.New("response not signed by the leaf's issuer or an authorized responder")
+}
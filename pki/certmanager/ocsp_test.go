@@ -0,0 +1,196 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOCSPSingleResponse struct {
+	CertID     ocspCertID
+	Status     asn1.RawValue
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type testOCSPResponseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []testOCSPSingleResponse
+}
+
+type testOCSPBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+// ocspResponse returns a DER OCSP response for leaf signed by key, reporting
+// it as good or revoked.
+func (ca *testCA) ocspResponse(t *testing.T, leaf *x509.Certificate, revoked bool, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+
+	id, err := newOCSPCertID(leaf, ca.cert)
+	if err != nil {
+		t.Fatalf("cert ID: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	status := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}
+	if revoked {
+		revokedAt, err := asn1.MarshalWithParams(now, "generalized")
+		if err != nil {
+			t.Fatalf("marshal revocation time: %v", err)
+		}
+		status = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: revokedAt}
+	}
+	keyHash, err := asn1.Marshal(id.KeyHash)
+	if err != nil {
+		t.Fatalf("marshal key hash: %v", err)
+	}
+	tbs, err := asn1.Marshal(testOCSPResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:  now,
+		Responses: []testOCSPSingleResponse{{
+			CertID:     id,
+			Status:     status,
+			ThisUpdate: now,
+			NextUpdate: now.Add(time.Hour),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("marshal response data: %v", err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("sign response: %v", err)
+	}
+	basic, err := asn1.Marshal(testOCSPBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	if err != nil {
+		t.Fatalf("marshal basic response: %v", err)
+	}
+	der, err := asn1.Marshal(ocspResponse{Response: ocspResponseBytes{Type: oidOCSPBasic, Response: basic}})
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	return der
+}
+
+func TestManagerStaplesOCSPFromResponderURL(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	bundle := ca.bundle(t, 7, testSpiffeID)
+	bundle.CACerts = []*x509.Certificate{ca.cert}
+	staple := ca.ocspResponse(t, bundle.Cert.Leaf, false, ca.key)
+
+	requests := make(chan []byte, 10)
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- body
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(staple)
+	}))
+	t.Cleanup(responder.Close)
+
+	mgr := NewWithOptions(staticIssuer{bundle: bundle}, Options{OCSPResponderURL: responder.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go mgr.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cert, err := mgr.GetCertificate(nil)
+		if err == nil && string(cert.OCSPStaple) == string(staple) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the OCSP staple")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var req ocspRequest
+	if _, err := asn1.Unmarshal(<-requests, &req); err != nil {
+		t.Fatalf("decode OCSP request: %v", err)
+	}
+	if ids := req.TBSRequest.RequestList; len(ids) != 1 || ids[0].CertID.SerialNumber.Int64() != 7 {
+		t.Fatalf("unexpected OCSP request: %+v", req)
+	}
+	if b, _ := mgr.Current(); b.Cert.OCSPStaple != nil {
+		t.Fatal("expected the bundle cert to be left unmodified")
+	}
+}
+
+func TestCheckOCSPResponseRejectsRevokedAndForged(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	leaf := ca.bundle(t, 3, testSpiffeID).Cert.Leaf
+
+	if _, err := checkOCSPResponse(ca.ocspResponse(t, leaf, false, ca.key), leaf, ca.cert, time.Now()); err != nil {
+		t.Fatalf("expected good response to verify: %v", err)
+	}
+	_, err := checkOCSPResponse(ca.ocspResponse(t, leaf, true, ca.key), leaf, ca.cert, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Fatalf("checkOCSPResponse() = %v, want revoked error", err)
+	}
+	forger, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkOCSPResponse(ca.ocspResponse(t, leaf, false, forger), leaf, ca.cert, time.Now()); err == nil {
+		t.Fatal("expected a response signed by another key to be rejected")
+	}
+}
+
+func TestCheckOCSPResponseRejectsExpired(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	leaf := ca.bundle(t, 3, testSpiffeID).Cert.Leaf
+	staple := ca.ocspResponse(t, leaf, false, ca.key)
+
+	if _, err := checkOCSPResponse(staple, leaf, ca.cert, time.Now().Add(30*time.Minute)); err != nil {
+		t.Fatalf("expected response before nextUpdate to verify: %v", err)
+	}
+	_, err := checkOCSPResponse(staple, leaf, ca.cert, time.Now().Add(2*time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("checkOCSPResponse() = %v, want expired error", err)
+	}
+}
+
+func TestManagerDropsExpiredStaple(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	bundle := ca.bundle(t, 3, testSpiffeID)
+	now := time.Now()
+	mgr := NewWithOptions(staticIssuer{bundle: bundle}, Options{Now: func() time.Time { return now }})
+	stapled := *bundle.Cert
+	stapled.OCSPStaple = []byte("staple")
+	mgr.staple.Store(&ocspStaple{base: bundle.Cert, cert: &stapled, nextUpdate: now.Add(time.Minute)})
+
+	if got := mgr.stapled(bundle.Cert); got != &stapled {
+		t.Fatal("expected a fresh staple to be served")
+	}
+	now = now.Add(2 * time.Minute)
+	if got := mgr.stapled(bundle.Cert); got != bundle.Cert {
+		t.Fatal("expected an expired staple to be dropped")
+	}
+}
@@ -0,0 +1,336 @@
+package certmanager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// pkcs12Iterations matches OpenSSL's default for both key encryption and MAC.
+const pkcs12Iterations = 2048
+
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidShroudedKeyBag  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256  = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+type pbes2Params struct {
+	KDF        pkix.AlgorithmIdentifier
+	Encryption pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+// ExportPKCS12 packages the current leaf, private key and CA chain as a
+// password-protected PKCS#12 (.p12) file for Java or Windows consumers. The
+// key is encrypted with PBES2 (PBKDF2-HMAC-SHA256, AES-256-CBC) and the file
+// is integrity-protected with an HMAC-SHA256 MAC, as OpenSSL 3 does by
+// default. Keys that cannot be exported (e.g. HSM-backed signers) fail.
+func (m *Manager) ExportPKCS12(password string) ([]byte, error) {
+	b, err := m.Current()
+	if err != nil {
+		return nil, err
+	}
+	return encodePKCS12(b, password)
+}
+
+func encodePKCS12(b *Bundle, password string) ([]byte, error) {
+	if b == nil || b.Cert == nil || len(b.Cert.Certificate) == 0 {
+		return nil, ErrNilBundle
+	}
+	if b.Cert.PrivateKey == nil {
+		return nil, errors.New("pkcs12: bundle has no private key")
+	}
+	if password == "" {
+		return nil, errors.New("pkcs12: password must not be empty")
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(b.Cert.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: marshal key: %w", err)
+	}
+
+	// localKeyID pairs the key with its leaf, which keystores rely on.
+	leafID := sha1.Sum(b.Cert.Certificate[0])
+	localKeyID, err := pkcs12Attr(oidLocalKeyID, leafID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	seen := make(map[string]struct{})
+	addCert := func(der []byte, attrs []pkcs12Attribute) error {
+		if _, ok := seen[string(der)]; ok {
+			return nil
+		}
+		seen[string(der)] = struct{}{}
+		value, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: der})
+		if err != nil {
+			return err
+		}
+		certBags = append(certBags, safeBag{ID: oidCertBag, Value: explicit0(value), Attributes: attrs})
+		return nil
+	}
+	if err := addCert(b.Cert.Certificate[0], []pkcs12Attribute{localKeyID}); err != nil {
+		return nil, fmt.Errorf("pkcs12: encode cert: %w", err)
+	}
+	for _, der := range b.Cert.Certificate[1:] {
+		if err := addCert(der, nil); err != nil {
+			return nil, fmt.Errorf("pkcs12: encode cert: %w", err)
+		}
+	}
+	for _, cert := range b.CACerts {
+		if err := addCert(cert.Raw, nil); err != nil {
+			return nil, fmt.Errorf("pkcs12: encode cert: %w", err)
+		}
+	}
+
+	shrouded, err := encryptPKCS8(keyDER, password)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{ID: oidShroudedKeyBag, Value: explicit0(shrouded), Attributes: []pkcs12Attribute{localKeyID}}}
+
+	var authSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		contents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs12: encode safe contents: %w", err)
+		}
+		info, err := dataContentInfo(contents)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, info)
+	}
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: encode authenticated safe: %w", err)
+	}
+
+	macSalt := make([]byte, 16)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(bmpPassword(password), macSalt, 3, pkcs12Iterations, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafeDER)
+
+	outer, err := dataContentInfo(authSafeDER)
+	if err != nil {
+		return nil, err
+	}
+	pfx, err := asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: outer,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: encode pfx: %w", err)
+	}
+	return pfx, nil
+}
+
+// encryptPKCS8 returns the DER EncryptedPrivateKeyInfo for keyDER.
+func encryptPKCS8(keyDER []byte, password string) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pkcs12Iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(keyDER)%aes.BlockSize
+	plain := append(bytes.Clone(keyDER), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs12Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KDF:        pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		Encryption: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      encrypted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: encode key: %w", err)
+	}
+	return out, nil
+}
+
+// dataContentInfo wraps content as a PKCS#7 data ContentInfo.
+func dataContentInfo(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, fmt.Errorf("pkcs12: encode content: %w", err)
+	}
+	return contentInfo{ContentType: oidData, Content: explicit0(octets)}, nil
+}
+
+// pkcs12Attr returns a bag attribute holding a single OCTET STRING value.
+func pkcs12Attr(id asn1.ObjectIdentifier, value []byte) (pkcs12Attribute, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{
+		ID:    id,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der},
+	}, nil
+}
+
+// explicit0 wraps der in an explicit [0] context tag.
+func explicit0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bmpPassword encodes password as a NUL-terminated big-endian UTF-16 string,
+// as the PKCS#12 key derivation function expects.
+func bmpPassword(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	out := make([]byte, 0, 2*len(units)+2)
+	for _, u := range units {
+		out = append(out, byte(u>>8), byte(u))
+	}
+	return append(out, 0, 0)
+}
+
+// pkcs12KDF implements the PKCS#12 key derivation function with SHA-256
+// (RFC 7292, appendix B.2). id selects the purpose; 3 derives MAC keys.
+func pkcs12KDF(password, salt []byte, id byte, iterations, size int) []byte {
+	const v = 64 // SHA-256 block size
+	fill := func(src []byte) []byte {
+		if len(src) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(src)+v-1)/v))
+		for i := range out {
+			out[i] = src[i%len(src)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	in := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h := sha256.New()
+		h.Write(d)
+		h.Write(in)
+		a := h.Sum(nil)
+		for range iterations - 1 {
+			sum := sha256.Sum256(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+
+		b := fill(a)
+		for j := 0; j < len(in); j += v {
+			// in[j:j+v] = (in[j:j+v] + b + 1) mod 2^(8v)
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(in[j+k]) + int(b[k]) + carry
+				in[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
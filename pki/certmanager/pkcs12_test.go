@@ -0,0 +1,144 @@
+package certmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestExportPKCS12(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	bundle := ca.bundle(t, 1, testSpiffeID)
+	bundle.CACerts = []*x509.Certificate{ca.cert}
+	mgr := New(staticIssuer{bundle: bundle})
+	if _, err := mgr.ExportPKCS12("changeit"); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("ExportPKCS12 err = %v, want ErrNotReady before Start", err)
+	}
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, err := mgr.ExportPKCS12(""); err == nil {
+		t.Fatal("expected an empty password to be rejected")
+	}
+	der, err := mgr.ExportPKCS12("changeit")
+	if err != nil {
+		t.Fatalf("ExportPKCS12 failed: %v", err)
+	}
+
+	var pfx pfxPDU
+	if _, err := asn1.Unmarshal(der, &pfx); err != nil {
+		t.Fatalf("decode pfx: %v", err)
+	}
+	var authSafeDER []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeDER); err != nil {
+		t.Fatalf("decode auth safe: %v", err)
+	}
+	// pkcs12KDF is pinned to OpenSSL's output by TestPKCS12KDFKnownAnswer.
+	macKey := pkcs12KDF(bmpPassword("changeit"), pfx.MacData.MacSalt, 3, pfx.MacData.Iterations, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafeDER)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Fatal("pfx MAC does not verify")
+	}
+
+	var safes []contentInfo
+	if _, err := asn1.Unmarshal(authSafeDER, &safes); err != nil || len(safes) != 2 {
+		t.Fatalf("decode safes: %v (%d)", err, len(safes))
+	}
+	var certBags, keyBags []safeBag
+	for i, dst := range []*[]safeBag{&certBags, &keyBags} {
+		var contents []byte
+		if _, err := asn1.Unmarshal(safes[i].Content.Bytes, &contents); err != nil {
+			t.Fatalf("decode safe %d: %v", i, err)
+		}
+		if _, err := asn1.Unmarshal(contents, dst); err != nil {
+			t.Fatalf("decode safe %d bags: %v", i, err)
+		}
+	}
+	if len(certBags) != 2 {
+		t.Fatalf("got %d cert bags, want leaf and CA", len(certBags))
+	}
+	var leaf certBag
+	if _, err := asn1.Unmarshal(certBags[0].Value.Bytes, &leaf); err != nil {
+		t.Fatalf("decode leaf bag: %v", err)
+	}
+	if !bytes.Equal(leaf.Data, bundle.Cert.Certificate[0]) {
+		t.Fatal("first cert bag is not the leaf")
+	}
+
+	if len(keyBags) != 1 {
+		t.Fatalf("got %d key bags, want 1", len(keyBags))
+	}
+	var shrouded encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(keyBags[0].Value.Bytes, &shrouded); err != nil {
+		t.Fatalf("decode key bag: %v", err)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(shrouded.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatalf("decode PBES2 params: %v", err)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KDF.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatalf("decode PBKDF2 params: %v", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Encryption.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("decode IV: %v", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, "changeit", kdf.Salt, kdf.Iterations, 32)
+	if err != nil {
+		t.Fatalf("derive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("new cipher: %v", err)
+	}
+	plain := make([]byte, len(shrouded.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, shrouded.Data)
+	plain = plain[:len(plain)-int(plain[len(plain)-1])]
+	want, err := x509.MarshalPKCS8PrivateKey(bundle.Cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	if !bytes.Equal(plain, want) {
+		t.Fatal("decrypted key does not match the bundle key")
+	}
+}
+
+func TestPKCS12KDFKnownAnswer(t *testing.T) {
+	t.Parallel()
+
+	// Expected values come from OpenSSL 3.0, e.g.:
+	//
+	//	openssl kdf -keylen 32 -kdfopt digest:SHA256 \
+	//	    -kdfopt hexpass:006300680061006e00670065006900740000 \
+	//	    -kdfopt hexsalt:0102030405060708090a0b0c0d0e0f10 \
+	//	    -kdfopt iter:2048 -kdfopt id:3 PKCS12KDF
+	salt, _ := hex.DecodeString("0102030405060708090a0b0c0d0e0f10")
+	for _, c := range []struct {
+		name       string
+		id         byte
+		iterations int
+		size       int
+		want       string
+	}{
+		{"MAC key", 3, 2048, 32, "c8d42dadc43b0b49638ec563627e5a336c82d8009af42de9d8ba30bb4cce36bb"},
+		{"two blocks", 1, 1, 64, "f1b78fdb8210015609c199547809b3a47e4016d216e5971cd744313c8ca4897cf6013349cecd8e47be73f57e71264d74cce0ae9a62e89c5aeeaa94032b54dfa2"},
+	} {
+		got := pkcs12KDF(bmpPassword("changeit"), salt, c.id, c.iterations, c.size)
+		if hex.EncodeToString(got) != c.want {
+			t.Errorf("%s: pkcs12KDF = %x, want %s", c.name, got, c.want)
+		}
+	}
+}
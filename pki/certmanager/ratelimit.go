@@ -0,0 +1,65 @@
+package certmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by RateLimitedIssuer when an issuance is
+// attempted sooner than MinInterval after the previous one.
+var ErrRateLimited = errors.New("issuance rate limited")
+
+// RateLimitedIssuer enforces MinInterval between Inner.Issue calls across
+// every caller sharing it, protecting the backend from runaway rotation
+// loops. Calls that come too soon fail with ErrRateLimited, or with Wait
+// block until their slot (or ctx expiry). Use it by pointer.
+type RateLimitedIssuer struct {
+	Inner       Issuer
+	MinInterval time.Duration
+	Wait        bool
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (r *RateLimitedIssuer) Issue(ctx context.Context) (*Bundle, error) {
+	if r.Inner == nil {
+		return nil, errors.New("rate limited inner issuer required")
+	}
+	r.mu.Lock()
+	now := time.Now()
+	next := r.last.Add(r.MinInterval)
+	if r.last.IsZero() || !now.Before(next) {
+		r.last = now
+		r.mu.Unlock()
+		return r.Inner.Issue(ctx)
+	}
+	delay := next.Sub(now)
+	if !r.Wait {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w: retry in %s", ErrRateLimited, delay)
+	}
+	// Reserve the next slot so concurrent waiters queue behind each other.
+	prev := r.last
+	r.last = next
+	r.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		// Give the slot back unless a later waiter has queued behind it,
+		// so abandoned waits do not push later callers back.
+		r.mu.Lock()
+		if r.last.Equal(next) {
+			r.last = prev
+		}
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	return r.Inner.Issue(ctx)
+}
@@ -0,0 +1,95 @@
+package certmanager
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedIssuerRejectsEarlyCalls(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	bundle := &Bundle{NotAfter: time.Now().Add(time.Hour)}
+	issuer := &RateLimitedIssuer{
+		Inner:       staticIssuer{bundle: bundle, calls: &calls},
+		MinInterval: 50 * time.Millisecond,
+	}
+
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("first Issue failed: %v", err)
+	}
+	if _, err := issuer.Issue(context.Background()); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("second Issue err = %v, want ErrRateLimited", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue after interval failed: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("inner calls = %d, want 2", got)
+	}
+}
+
+func TestRateLimitedIssuerWaits(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	bundle := &Bundle{NotAfter: time.Now().Add(time.Hour)}
+	issuer := &RateLimitedIssuer{
+		Inner:       staticIssuer{bundle: bundle, calls: &calls},
+		MinInterval: 30 * time.Millisecond,
+		Wait:        true,
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := issuer.Issue(context.Background()); err != nil {
+			t.Fatalf("Issue %d failed: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("3 calls took %s, want at least 2 intervals", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := issuer.Issue(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Issue with canceled ctx err = %v, want context.Canceled", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("inner calls = %d, want 3", got)
+	}
+}
+
+func TestRateLimitedIssuerReleasesCanceledSlot(t *testing.T) {
+	t.Parallel()
+
+	bundle := &Bundle{NotAfter: time.Now().Add(time.Hour)}
+	issuer := &RateLimitedIssuer{
+		Inner:       staticIssuer{bundle: bundle},
+		MinInterval: 200 * time.Millisecond,
+		Wait:        true,
+	}
+	start := time.Now()
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	// A waiter gives up before its slot.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := issuer.Issue(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Issue err = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The next caller takes the released slot instead of queueing behind it.
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 350*time.Millisecond {
+		t.Fatalf("next call waited until %s, want the canceled slot released", elapsed)
+	}
+}
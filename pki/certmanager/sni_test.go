@@ -0,0 +1,54 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetCertificateSelectsBySNI(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	defaultBundle := ca.bundle(t, 1, testSpiffeID)
+	apiBundle := ca.bundle(t, 2, testSpiffeID)
+
+	mgr := NewWithOptions(staticIssuer{bundle: defaultBundle}, Options{
+		SNIIssuers: map[string]Issuer{
+			"API.corp.internal": staticIssuer{bundle: apiBundle},
+		},
+	})
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	got, err := mgr.GetCertificate(&tls.ClientHelloInfo{ServerName: "api.corp.internal"})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if got != apiBundle.Cert {
+		t.Fatal("expected SNI-specific cert for api.corp.internal")
+	}
+
+	got, err = mgr.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.corp.internal"})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if got != defaultBundle.Cert {
+		t.Fatal("expected default cert for unmapped server name")
+	}
+}
+
+func TestStartReportsSNIIssuerErrors(t *testing.T) {
+	t.Parallel()
+
+	down := errors.New("role missing")
+	mgr := NewWithOptions(staticIssuer{bundle: &Bundle{NotAfter: time.Now().Add(time.Hour)}}, Options{
+		SNIIssuers: map[string]Issuer{"api": staticIssuer{err: down}},
+	})
+	if err := mgr.Start(context.Background()); !errors.Is(err, down) {
+		t.Fatalf("Start() = %v, want SNI issuer error", err)
+	}
+}
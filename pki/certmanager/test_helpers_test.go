@@ -0,0 +1,102 @@
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway CA for issuing leaf bundles in tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// bundle issues an ECDSA leaf for 127.0.0.1 carrying the given SPIFFE ID.
+func (ca *testCA) bundle(t *testing.T, serial int64, spiffeID string) *Bundle {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate leaf key: %v", err)
+	}
+	return ca.bundleWithKey(t, serial, spiffeID, key)
+}
+
+// bundleWithKey issues a leaf for key, carrying the given SPIFFE ID.
+func (ca *testCA) bundleWithKey(t *testing.T, serial int64, spiffeID string, key crypto.Signer) *Bundle {
+	t.Helper()
+
+	id, err := url.Parse(spiffeID)
+	if err != nil {
+		t.Fatalf("parse SPIFFE ID: %v", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "Test Leaf"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(30 * time.Minute),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		URIs:         []*url.URL{id},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse leaf cert: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return &Bundle{
+		Cert: &tls.Certificate{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+			Leaf:        leaf,
+		},
+		CA:       pool,
+		CACerts:  []*x509.Certificate{ca.cert},
+		NotAfter: leaf.NotAfter,
+	}
+}
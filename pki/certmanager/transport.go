@@ -0,0 +1,66 @@
+package certmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	"github.com/cmmoran/spiffe-rotate/pki/spiffe"
+)
+
+// Transport returns an http.Transport that presents the manager's current
+// client cert and authorizes servers with auth. Server chains are verified
+// against the manager's current CA pool at handshake time, so trust follows
+// rotations without rebuilding the transport. Hostnames are not checked; the
+// SPIFFE ID authorizes the peer instead. When auth.VerifiesRaw, server chains
+// are verified by auth (e.g. against federated bundles) instead.
+func Transport(m *Manager, auth spiffe.Authorizer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = clientConfig(m, auth)
+	return transport
+}
+
+func clientConfig(m *Manager, auth spiffe.Authorizer) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: m.GetClientCertificate,
+		// Verification happens in VerifyConnection against the current CA pool.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if auth.VerifiesRaw() {
+				raw := make([][]byte, 0, len(cs.PeerCertificates))
+				for _, cert := range cs.PeerCertificates {
+					raw = append(raw, cert.Raw)
+				}
+				return auth.VerifyPeerCertificate(raw, nil)
+			}
+			chains, err := m.verifyPeer(cs.PeerCertificates, x509.ExtKeyUsageServerAuth)
+			if err != nil {
+				return err
+			}
+			return auth.VerifyPeerCertificate(nil, chains)
+		},
+	}
+}
+
+// verifyPeer verifies a peer chain against the current bundle's CA pool.
+func (m *Manager) verifyPeer(certs []*x509.Certificate, usage x509.ExtKeyUsage) ([][]*x509.Certificate, error) {
+	b, err := m.Current()
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no peer certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(x509.VerifyOptions{
+		Roots:         m.trustPool(b),
+		Intermediates: intermediates,
+		CurrentTime:   m.opts.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+}
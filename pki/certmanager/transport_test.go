@@ -0,0 +1,58 @@
+package certmanager
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/cmmoran/spiffe-rotate/pki/spiffe"
+)
+
+func TestTransportMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	server := New(staticIssuer{bundle: ca.bundle(t, 1, testSpiffeID)})
+	client := New(staticIssuer{bundle: ca.bundle(t, 2, "spiffe://corp/prod/stack/payments/service/worker")})
+	for _, mgr := range []*Manager{server, client} {
+		if err := mgr.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+	}
+
+	ln, err := Listen("tcp", "127.0.0.1:0", server, spiffe.Authorizer{
+		AllowedPrefixes: []string{"spiffe://corp/prod/stack/payments/"},
+	})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), ErrorLog: log.New(io.Discard, "", 0)}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	url := "https://" + ln.Addr().String()
+
+	httpClient := &http.Client{Transport: Transport(client, spiffe.Authorizer{
+		AllowedExact: []string{testSpiffeID},
+	})}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	denied := &http.Client{Transport: Transport(client, spiffe.Authorizer{
+		AllowedExact: []string{"spiffe://corp/prod/stack/other/service/api"},
+	})}
+	if resp, err := denied.Get(url); err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected unauthorized server SPIFFE ID to be rejected")
+	}
+}
@@ -0,0 +1,178 @@
+// Package kubeapi is the minimal in-cluster Kubernetes API client shared by
+// the packages that talk to the API server over plain HTTP, so they need no
+// client libraries.
+package kubeapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-cluster service account files used when the corresponding fields are
+// unset.
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultTokenFile  = serviceAccountDir + "/token"
+	defaultCAFile     = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// Client sends JSON requests to the Kubernetes API. Unset fields default to
+// the pod's service account. It holds no state, so callers may build one per
+// request from their own configuration.
+type Client struct {
+	// APIServer is the Kubernetes API URL. Defaults to the in-cluster
+	// KUBERNETES_SERVICE_HOST/PORT address.
+	APIServer string
+	// Token authenticates to the API server. TokenFile is re-read for every
+	// request so projected tokens keep working; it defaults to the service
+	// account token when Token is empty.
+	Token     string
+	TokenFile string
+	// HTTPClient defaults to a shared client trusting the service account CA.
+	HTTPClient *http.Client
+	// Timeout, if positive, bounds each request.
+	Timeout time.Duration
+}
+
+// Do sends body, if not nil, as JSON to resource (an API path such as
+// /api/v1/namespaces/apps/events) and decodes the response into out, if not
+// nil. Non-2xx responses are reported with the API's status message.
+func (c Client) Do(ctx context.Context, method, resource string, body, out any) error {
+	base, err := c.apiServer()
+	if err != nil {
+		return err
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return err
+	}
+	token, err := c.token()
+	if err != nil {
+		return err
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(base, "/")+resource, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &status) == nil && status.Message != "" {
+			return fmt.Errorf("kubernetes api %s %s: http %d: %s", method, resource, resp.StatusCode, status.Message)
+		}
+		return fmt.Errorf("kubernetes api %s %s: http %d", method, resource, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("kubernetes api %s %s: decode: %w", method, resource, err)
+	}
+	return nil
+}
+
+// Namespace returns configured, or the pod's namespace if it is empty.
+func Namespace(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	b, err := os.ReadFile(namespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("namespace unset and not running in a pod: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (c Client) apiServer() (string, error) {
+	if c.APIServer != "" {
+		return c.APIServer, nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return "", errors.New("kubernetes api server unknown: set APIServer outside a cluster")
+	}
+	return "https://" + net.JoinHostPort(host, port), nil
+}
+
+func (c Client) token() (string, error) {
+	if c.Token != "" {
+		return c.Token, nil
+	}
+	file := c.TokenFile
+	if file == "" {
+		file = defaultTokenFile
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("read kubernetes token: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (c Client) httpClient() (*http.Client, error) {
+	if c.HTTPClient != nil {
+		return c.HTTPClient, nil
+	}
+	return inCluster()
+}
+
+// inCluster builds, once per process, a client trusting the service
+// account CA.
+var inCluster = sync.OnceValues(func() (*http.Client, error) {
+	caPEM, err := os.ReadFile(defaultCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read kubernetes CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("kubernetes CA file holds no certificates")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+})
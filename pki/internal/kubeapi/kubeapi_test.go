@@ -0,0 +1,53 @@
+package kubeapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientDo(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"pods is forbidden"}`))
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", r.Header.Get("Content-Type"))
+		}
+		_, _ = w.Write([]byte(`{"metadata":{"name":"created"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client := Client{APIServer: server.URL + "/", Token: "sa-token", HTTPClient: server.Client()}
+	var out struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	if err := client.Do(context.Background(), http.MethodPost, "/api/v1/things", map[string]string{"a": "b"}, &out); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if out.Metadata.Name != "created" {
+		t.Fatalf("decoded %+v, want the response body", out)
+	}
+
+	client.Token = "wrong"
+	err := client.Do(context.Background(), http.MethodPost, "/api/v1/things", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "http 403: pods is forbidden") {
+		t.Fatalf("Do() = %v, want the API status message", err)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	t.Parallel()
+
+	if ns, err := Namespace("apps"); err != nil || ns != "apps" {
+		t.Fatalf("Namespace(apps) = %q, %v", ns, err)
+	}
+}
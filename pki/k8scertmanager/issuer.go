@@ -0,0 +1,274 @@
+// Package k8scertmanager issues certmanager bundles through a Kubernetes
+// cert-manager CertificateRequest, so workloads get the same in-process
+// rotation whether certs come from Vault directly or from cert-manager. It
+// talks to the Kubernetes API over plain HTTP and needs no client libraries.
+package k8scertmanager
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+	"github.com/cmmoran/spiffe-rotate/pki/internal/kubeapi"
+)
+
+// ErrDenied is returned when the CertificateRequest is denied or fails.
+var ErrDenied = errors.New("certificate request denied")
+
+// IssuerRef names the cert-manager issuer that signs requests.
+type IssuerRef struct {
+	Name string `json:"name"`
+	// Kind is "Issuer" (default) or "ClusterIssuer".
+	Kind string `json:"kind,omitempty"`
+	// Group defaults to cert-manager.io.
+	Group string `json:"group,omitempty"`
+}
+
+// Issuer creates a CertificateRequest for a fresh key on every Issue call,
+// waits for cert-manager to sign it and returns the bundle. The request is
+// deleted once it has been read. Inside a pod, the API server, token, CA and
+// namespace default to the service account's, which needs create, get and
+// delete on certificaterequests.cert-manager.io. Requests must be approved;
+// cert-manager's default approver does so for its own issuers.
+type Issuer struct {
+	// APIServer is the Kubernetes API URL. Defaults to the in-cluster
+	// KUBERNETES_SERVICE_HOST/PORT address.
+	APIServer string
+	// Token authenticates to the API server. TokenFile is re-read on every
+	// Issue so projected tokens keep working; it defaults to the service
+	// account token when Token is empty.
+	Token     string
+	TokenFile string
+	// HTTPClient defaults to a client trusting the service account CA.
+	HTTPClient *http.Client
+	// Namespace defaults to the pod's namespace.
+	Namespace string
+	IssuerRef IssuerRef
+
+	CommonName string
+	DNSNames   []string
+	IPSANs     []string
+	URISANs    []string
+	// Duration is the requested lifetime. Zero defers to the issuer.
+	Duration time.Duration
+	// Usages are cert-manager key usages; defaults to digital signature,
+	// key encipherment, server auth and client auth.
+	Usages []string
+	// PollInterval is how often the request status is checked. Default: 2s.
+	PollInterval time.Duration
+}
+
+// certificateRequest is the subset of the cert-manager v1 CertificateRequest
+// the issuer reads and writes.
+type certificateRequest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name         string `json:"name,omitempty"`
+		GenerateName string `json:"generateName,omitempty"`
+		Namespace    string `json:"namespace,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		Request   []byte    `json:"request"`
+		IssuerRef IssuerRef `json:"issuerRef"`
+		Duration  string    `json:"duration,omitempty"`
+		Usages    []string  `json:"usages,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Certificate []byte `json:"certificate,omitempty"`
+		CA          []byte `json:"ca,omitempty"`
+		Conditions  []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Reason  string `json:"reason,omitempty"`
+			Message string `json:"message,omitempty"`
+		} `json:"conditions,omitempty"`
+	} `json:"status"`
+}
+
+func (i *Issuer) Issue(ctx context.Context) (*certmanager.Bundle, error) {
+	if i.IssuerRef.Name == "" {
+		return nil, errors.New("cert-manager issuer name required")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	csr, err := i.newCSR(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ns, err := kubeapi.Namespace(i.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var cr certificateRequest
+	cr.APIVersion, cr.Kind = "cert-manager.io/v1", "CertificateRequest"
+	cr.Metadata.GenerateName = "spiffe-rotate-"
+	cr.Metadata.Namespace = ns
+	cr.Spec.Request = csr
+	cr.Spec.IssuerRef = i.IssuerRef
+	if i.Duration > 0 {
+		cr.Spec.Duration = i.Duration.String()
+	}
+	cr.Spec.Usages = i.Usages
+	if len(cr.Spec.Usages) == 0 {
+		cr.Spec.Usages = []string{"digital signature", "key encipherment", "server auth", "client auth"}
+	}
+
+	collection := path.Join("/apis/cert-manager.io/v1/namespaces", ns, "certificaterequests")
+	var created certificateRequest
+	if err := i.do(ctx, http.MethodPost, collection, cr, &created); err != nil {
+		return nil, fmt.Errorf("create certificate request: %w", err)
+	}
+	name := created.Metadata.Name
+	if name == "" {
+		// Never clean up without a name: DELETE on the collection would
+		// remove every CertificateRequest in the namespace.
+		return nil, errors.New("create certificate request: response has no metadata.name")
+	}
+	defer func() {
+		dctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		_ = i.do(dctx, http.MethodDelete, path.Join(collection, name), nil, nil)
+	}()
+
+	signed, err := i.wait(ctx, path.Join(collection, name), created)
+	if err != nil {
+		return nil, fmt.Errorf("certificate request %s/%s: %w", ns, name, err)
+	}
+	return newBundle(signed.Status.Certificate, signed.Status.CA, key)
+}
+
+// wait polls the request until it is ready, denied or failed.
+func (i *Issuer) wait(ctx context.Context, resource string, cr certificateRequest) (certificateRequest, error) {
+	interval := i.PollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	for {
+		for _, cond := range cr.Status.Conditions {
+			switch {
+			case cond.Type == "Denied" && cond.Status == "True":
+				return cr, fmt.Errorf("%w: %s", ErrDenied, cond.Message)
+			case cond.Type == "Ready" && cond.Status == "False" && cond.Reason == "Failed":
+				return cr, fmt.Errorf("%w: failed: %s", ErrDenied, cond.Message)
+			case cond.Type == "Ready" && cond.Status == "True" && len(cr.Status.Certificate) > 0:
+				return cr, nil
+			}
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return cr, fmt.Errorf("waiting to be signed: %w", ctx.Err())
+		case <-timer.C:
+		}
+		cr = certificateRequest{}
+		if err := i.do(ctx, http.MethodGet, resource, nil, &cr); err != nil {
+			return cr, err
+		}
+	}
+}
+
+func (i *Issuer) newCSR(key crypto.Signer) ([]byte, error) {
+	tmpl := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: i.CommonName},
+		DNSNames: i.DNSNames,
+	}
+	for _, raw := range i.IPSANs {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip SAN %q", raw)
+		}
+		tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+	}
+	for _, raw := range i.URISANs {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" {
+			return nil, fmt.Errorf("invalid uri SAN %q", raw)
+		}
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, fmt.Errorf("create csr: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// newBundle builds a bundle from the PEM chain and CA cert-manager returned.
+func newBundle(chainPEM, caPEM []byte, key crypto.Signer) (*certmanager.Bundle, error) {
+	var cert tls.Certificate
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("certificate request status holds no certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse issued certificate: %w", err)
+	}
+	pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(key.Public()) {
+		return nil, errors.New("issued certificate does not match the requested key")
+	}
+	cert.Leaf, cert.PrivateKey = leaf, key
+
+	pool := x509.NewCertPool()
+	var caCerts []*x509.Certificate
+	for rest := caPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse CA certificate: %w", err)
+		}
+		pool.AddCert(ca)
+		caCerts = append(caCerts, ca)
+	}
+	return &certmanager.Bundle{
+		Cert:     &cert,
+		CA:       pool,
+		CACerts:  caCerts,
+		NotAfter: leaf.NotAfter,
+	}, nil
+}
+
+// do sends body as JSON to resource and decodes the response into out.
+func (i *Issuer) do(ctx context.Context, method, resource string, body, out any) error {
+	client := kubeapi.Client{
+		APIServer:  i.APIServer,
+		Token:      i.Token,
+		TokenFile:  i.TokenFile,
+		HTTPClient: i.HTTPClient,
+		Timeout:    30 * time.Second,
+	}
+	return client.Do(ctx, method, resource, body, out)
+}
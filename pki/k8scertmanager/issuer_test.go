@@ -0,0 +1,240 @@
+package k8scertmanager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testCollection = "/apis/cert-manager.io/v1/namespaces/apps/certificaterequests"
+
+// fakeAPI is a minimal Kubernetes API serving CertificateRequests. Requests
+// become Ready on their second GET, signed by its CA, unless deny is set.
+type fakeAPI struct {
+	t      *testing.T
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPEM  []byte
+	deny   bool
+	noName bool // create responses omit metadata.name
+	mu     sync.Mutex
+	// collectionDeletes counts DELETEs of the whole collection.
+	collectionDeletes int
+	gets              int
+	stored            map[string]*certificateRequest
+	seen              certificateRequest
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Cluster CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	return &fakeAPI{
+		t:      t,
+		ca:     ca,
+		caKey:  key,
+		caPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		stored: map[string]*certificateRequest{},
+	}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer sa-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message":"Unauthorized"}`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == testCollection:
+		var cr certificateRequest
+		_ = json.NewDecoder(r.Body).Decode(&cr)
+		f.seen = cr
+		cr.Metadata.Name = cr.Metadata.GenerateName + "abcde"
+		f.stored[cr.Metadata.Name] = &cr
+		if f.noName {
+			cr.Metadata.Name = ""
+		}
+		_ = json.NewEncoder(w).Encode(cr)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, testCollection+"/"):
+		cr, ok := f.stored[strings.TrimPrefix(r.URL.Path, testCollection+"/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f.gets++
+		if f.gets >= 2 {
+			f.complete(cr)
+		}
+		_ = json.NewEncoder(w).Encode(cr)
+	case r.Method == http.MethodDelete:
+		if r.URL.Path == testCollection {
+			f.collectionDeletes++
+		}
+		delete(f.stored, strings.TrimPrefix(r.URL.Path, testCollection+"/"))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeAPI) complete(cr *certificateRequest) {
+	type condition = struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Reason  string `json:"reason,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	if f.deny {
+		cr.Status.Conditions = []condition{{Type: "Denied", Status: "True", Message: "policy says no"}}
+		return
+	}
+	block, _ := pem.Decode(cr.Spec.Request)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		f.t.Errorf("parse CSR: %v", err)
+		return
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      csr.Subject,
+		URIs:         csr.URIs,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(30 * time.Minute),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, f.ca, csr.PublicKey, f.caKey)
+	if err != nil {
+		f.t.Errorf("sign CSR: %v", err)
+		return
+	}
+	cr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	cr.Status.CA = f.caPEM
+	cr.Status.Conditions = []condition{{Type: "Ready", Status: "True", Reason: "Issued"}}
+}
+
+func TestIssuerIssuesViaCertificateRequest(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t)
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		APIServer:    server.URL,
+		Token:        "sa-token",
+		HTTPClient:   server.Client(),
+		Namespace:    "apps",
+		IssuerRef:    IssuerRef{Name: "mesh-ca", Kind: "ClusterIssuer"},
+		URISANs:      []string{"spiffe://corp/apps/api"},
+		Duration:     time.Hour,
+		PollInterval: 10 * time.Millisecond,
+	}
+	bundle, err := issuer.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if bundle.Cert.Leaf.SerialNumber.Int64() != 42 || bundle.Cert.Leaf.URIs[0].String() != "spiffe://corp/apps/api" {
+		t.Fatalf("unexpected leaf: %+v", bundle.Cert.Leaf)
+	}
+	if _, err := bundle.Cert.Leaf.Verify(x509.VerifyOptions{Roots: bundle.CA}); err != nil {
+		t.Fatalf("leaf does not verify against returned CA: %v", err)
+	}
+	if !bundle.NotAfter.Equal(bundle.Cert.Leaf.NotAfter) {
+		t.Fatal("expected NotAfter to come from the leaf")
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.seen.Spec.IssuerRef.Name != "mesh-ca" || api.seen.Spec.IssuerRef.Kind != "ClusterIssuer" || api.seen.Spec.Duration != "1h0m0s" {
+		t.Fatalf("unexpected request spec: %+v", api.seen.Spec)
+	}
+	if len(api.stored) != 0 {
+		t.Fatal("expected the certificate request to be deleted after issuance")
+	}
+}
+
+func TestIssuerDenied(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t)
+	api.deny = true
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		APIServer:    server.URL,
+		Token:        "sa-token",
+		HTTPClient:   server.Client(),
+		Namespace:    "apps",
+		IssuerRef:    IssuerRef{Name: "mesh-ca"},
+		PollInterval: 10 * time.Millisecond,
+	}
+	_, err := issuer.Issue(context.Background())
+	if !errors.Is(err, ErrDenied) || !strings.Contains(err.Error(), "policy says no") {
+		t.Fatalf("Issue() = %v, want ErrDenied with the reason", err)
+	}
+
+	issuer.Token = "wrong"
+	if _, err := issuer.Issue(context.Background()); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Fatalf("Issue() = %v, want the API error message", err)
+	}
+}
+
+func TestIssuerRejectsUnnamedRequest(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t)
+	api.noName = true
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		APIServer:    server.URL,
+		Token:        "sa-token",
+		HTTPClient:   server.Client(),
+		Namespace:    "apps",
+		IssuerRef:    IssuerRef{Name: "mesh-ca"},
+		PollInterval: 10 * time.Millisecond,
+	}
+	if _, err := issuer.Issue(context.Background()); err == nil || !strings.Contains(err.Error(), "metadata.name") {
+		t.Fatalf("Issue() = %v, want an error for the unnamed request", err)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.collectionDeletes != 0 {
+		t.Fatal("expected no DELETE of the certificate request collection")
+	}
+}
@@ -1,11 +1,24 @@
 package spiffe
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
 	"strings"
 )
 
+// oidSCTList is the embedded Signed Certificate Timestamp list extension (RFC 6962).
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// Authorizer decides which SPIFFE IDs may connect. Its methods only read the
+// rule slices, so one Authorizer may verify concurrent handshakes, but the
+// slices must not be modified while it is in use: build a new Authorizer and
+// swap it in through a ReloadableAuthorizer instead.
 type Authorizer struct {
 	// AllowedExact matches full SPIFFE IDs only.
 	AllowedExact []string
@@ -13,39 +26,376 @@ type Authorizer struct {
 	AllowedPrefixes []string
 	// AllowedGlobs supports `+` for single segment and trailing `*` for suffixes.
 	AllowedGlobs []string
+	// AllowedRegexps matches the full SPIFFE ID, after unreserved characters
+	// are percent-decoded and a trailing slash is stripped, for policies prefixes and globs cannot
+	// express. Regexps are powerful but easy to get wrong: MatchString finds
+	// substrings, so anchor every pattern with ^ and $, and escape dots.
+	// With FederatedBundles, VerifyPeerCertificate only matches IDs in the
+	// trust domain the chain was verified for, so even a pattern such as
+	// ^spiffe://[^/]+/admin$ cannot admit another trust domain's ID.
+	AllowedRegexps []*regexp.Regexp
+	// RequireSCT rejects peer leaves without an embedded SCT list extension.
+	RequireSCT bool
+	// FederatedBundles binds each trust domain (e.g. "corp") to its own trust
+	// anchors. When set, VerifyPeerCertificate ignores verifiedChains and
+	// verifies rawCerts against the bundle for the trust domain of the leaf's
+	// first SPIFFE ID, so the TLS config must not verify chains itself (e.g.
+	// use tls.RequireAnyClientCert). Only IDs in that trust domain are then
+	// matched, and peers from unlisted trust domains are rejected.
+	FederatedBundles map[string]*x509.CertPool
+	// PinnedLeafSHA256 authorizes peers whose leaf DER has one of these
+	// SHA-256 fingerprints, bypassing chain, SCT and SPIFFE ID checks. It is a
+	// break-glass escape hatch: keep the list short and the keys tightly held.
+	PinnedLeafSHA256 [][sha256.Size]byte
+	// VerifyFromRaw makes VerifyPeerCertificate ignore verifiedChains and
+	// verify rawCerts against Roots itself, for TLS configs that skip chain
+	// verification (e.g. tls.RequestClientCert or InsecureSkipVerify).
+	// FederatedBundles, when set, takes precedence over Roots.
+	VerifyFromRaw bool
+	// Roots is the trust pool used by VerifyFromRaw.
+	Roots *x509.CertPool
+	// AllowedPublicKeyAlgorithms, if set, rejects peer leaves whose public
+	// key algorithm is not listed (e.g. only x509.Ed25519), even when the
+	// SPIFFE ID matches.
+	AllowedPublicKeyAlgorithms []x509.PublicKeyAlgorithm
+	// SameTrustDomainAs authorizes any peer whose SPIFFE ID is in this trust
+	// domain, in addition to the other rules. It takes a SPIFFE ID (e.g. the
+	// workload's own) or a bare trust domain such as "corp", for "anyone in
+	// my mesh" policies that keep separately named environments apart.
+	SameTrustDomainAs string
+	// RequireSingleURI rejects peer leaves carrying more than one spiffe://
+	// URI SAN. The SPIFFE spec allows exactly one, yet by default a leaf is
+	// authorized if any of its IDs matches, so a non-compliant or compromised
+	// issuer that adds an extra URI could smuggle an allowed identity next
+	// to the workload's real one.
+	RequireSingleURI bool
+	// StrictChain rejects verified chains whose non-leaf certs carry a
+	// spiffe:// URI SAN. Only leaf IDs are ever matched; this is defense in
+	// depth against chains built to smuggle identities.
+	StrictChain bool
+}
+
+// clone returns a copy of a whose rule slices and maps do not alias a's.
+// Cert pools are shared; they are safe for concurrent use.
+func (a Authorizer) clone() *Authorizer {
+	a.AllowedExact = slices.Clone(a.AllowedExact)
+	a.AllowedPrefixes = slices.Clone(a.AllowedPrefixes)
+	a.AllowedGlobs = slices.Clone(a.AllowedGlobs)
+	a.AllowedRegexps = slices.Clone(a.AllowedRegexps)
+	a.PinnedLeafSHA256 = slices.Clone(a.PinnedLeafSHA256)
+	a.AllowedPublicKeyAlgorithms = slices.Clone(a.AllowedPublicKeyAlgorithms)
+	a.FederatedBundles = maps.Clone(a.FederatedBundles)
+	return &a
+}
+
+// VerifiesRaw reports whether VerifyPeerCertificate verifies rawCerts itself,
+// so TLS configs built around the authorizer can skip chain verification.
+func (a Authorizer) VerifiesRaw() bool {
+	return a.VerifyFromRaw || len(a.FederatedBundles) > 0
 }
 
 // VerifyPeerCertificate can be used as tls.Config.VerifyPeerCertificate.
-func (a Authorizer) VerifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
-	// We trust verifiedChains (already validated by TLS) and ignore rawCerts.
+func (a Authorizer) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if a.pinned(rawCerts, verifiedChains) {
+		return nil
+	}
+	// td is the trust domain the chain was verified for, when federated.
+	var td string
+	if a.VerifiesRaw() {
+		chains, verifiedTD, err := a.verifyRaw(rawCerts)
+		if err != nil {
+			return err
+		}
+		verifiedChains, td = chains, verifiedTD
+	}
+	// Otherwise we trust verifiedChains (already validated by TLS) and
+	// ignore rawCerts.
 	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
 		return errors.New("no verified chain")
 	}
 	leaf := verifiedChains[0][0]
-	for _, uri := range leaf.URIs {
-		if uri.Scheme != "spiffe" {
-			continue
-		}
-		id := uri.String()
-		for _, exact := range a.AllowedExact {
-			if id == exact {
-				return nil
+	if a.StrictChain {
+		for _, cert := range verifiedChains[0][1:] {
+			if hasSPIFFEURI(cert) {
+				return fmt.Errorf("SPIFFE URI on non-leaf certificate %q", cert.Subject)
 			}
 		}
-		for _, prefix := range a.AllowedPrefixes {
-			if strings.HasPrefix(id, prefix) {
-				return nil
-			}
+	}
+	if a.RequireSingleURI {
+		if n := countSPIFFEURIs(leaf); n > 1 {
+			return fmt.Errorf("peer certificate carries %d SPIFFE URIs, want 1", n)
 		}
-		for _, glob := range a.AllowedGlobs {
-			if matchGlob(glob, id) {
-				return nil
-			}
+	}
+	if a.RequireSCT && !hasExtension(leaf, oidSCTList) {
+		return errors.New("peer certificate missing embedded SCTs")
+	}
+	if len(a.AllowedPublicKeyAlgorithms) > 0 && !slices.Contains(a.AllowedPublicKeyAlgorithms, leaf.PublicKeyAlgorithm) {
+		return fmt.Errorf("peer public key algorithm %s not allowed", leaf.PublicKeyAlgorithm)
+	}
+	for _, id := range IDsFromCert(leaf) {
+		// Only the bundle for td vouched for the issuer, so IDs in other
+		// trust domains must not authorize the peer.
+		if td != "" && trustDomain(id) != td {
+			continue
+		}
+		if a.Allow(id) == nil {
+			return nil
 		}
 	}
 	return errors.New("client SPIFFE ID not allowed")
 }
 
+// pinned reports whether the peer leaf matches PinnedLeafSHA256. The TLS
+// handshake has already proven possession of the leaf's key.
+func (a Authorizer) pinned(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) bool {
+	if len(a.PinnedLeafSHA256) == 0 {
+		return false
+	}
+	var leaf []byte
+	switch {
+	case len(rawCerts) > 0:
+		leaf = rawCerts[0]
+	case len(verifiedChains) > 0 && len(verifiedChains[0]) > 0:
+		leaf = verifiedChains[0][0].Raw
+	}
+	if len(leaf) == 0 {
+		return false
+	}
+	sum := sha256.Sum256(leaf)
+	for _, pin := range a.PinnedLeafSHA256 {
+		if sum == pin {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyRaw verifies the raw peer chain against the trust bundle of the
+// leaf's SPIFFE ID trust domain, or against Roots without federation. It
+// returns the federated trust domain verified for, or "" for Roots.
+func (a Authorizer) verifyRaw(rawCerts [][]byte) ([][]*x509.Certificate, string, error) {
+	if len(rawCerts) == 0 {
+		return nil, "", errors.New("no peer certificate")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, "", fmt.Errorf("parse peer certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	leaf := certs[0]
+	roots, td, err := a.rootsFor(leaf)
+	if err != nil {
+		return nil, "", err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		if td == "" {
+			return nil, "", fmt.Errorf("verify peer against roots: %w", err)
+		}
+		return nil, "", fmt.Errorf("verify peer against trust domain %q bundle: %w", td, err)
+	}
+	return chains, td, nil
+}
+
+// rootsFor returns the trust pool for leaf and its federated trust domain,
+// or "" when verifying against Roots.
+func (a Authorizer) rootsFor(leaf *x509.Certificate) (*x509.CertPool, string, error) {
+	if len(a.FederatedBundles) == 0 {
+		if a.Roots == nil {
+			return nil, "", errors.New("VerifyFromRaw requires Roots")
+		}
+		return a.Roots, "", nil
+	}
+	ids := IDsFromCert(leaf)
+	if len(ids) == 0 {
+		return nil, "", errors.New("peer certificate has no SPIFFE ID")
+	}
+	td := trustDomain(ids[0])
+	roots, ok := a.FederatedBundles[td]
+	if !ok || roots == nil {
+		return nil, "", fmt.Errorf("no trust bundle for trust domain %q", td)
+	}
+	return roots, td, nil
+}
+
+// IDsFromCert returns the well-formed SPIFFE IDs among cert's URI SANs, in
+// certificate order. URIs with other schemes, no trust domain, or a port,
+// user info, query or fragment are skipped.
+func IDsFromCert(cert *x509.Certificate) []string {
+	if cert == nil {
+		return nil
+	}
+	var ids []string
+	for _, uri := range cert.URIs {
+		if uri == nil || uri.Scheme != "spiffe" || uri.Host == "" || uri.Port() != "" ||
+			uri.User != nil || uri.Opaque != "" || uri.RawQuery != "" || uri.Fragment != "" {
+			continue
+		}
+		ids = append(ids, uri.String())
+	}
+	return ids
+}
+
+// Allow applies the authorizer rules to a bare SPIFFE ID, e.g. one taken from
+// a JWT or request header rather than a TLS peer certificate.
+func (a Authorizer) Allow(id string) error {
+	if !strings.HasPrefix(id, "spiffe://") {
+		return errors.New("not a SPIFFE ID")
+	}
+	id = normalizeID(id)
+	if td := trustDomain(a.SameTrustDomainAs); td != "" && trustDomain(id) == td {
+		return nil
+	}
+	for _, exact := range a.AllowedExact {
+		if id == normalizeID(exact) {
+			return nil
+		}
+	}
+	for _, prefix := range a.AllowedPrefixes {
+		if MatchPrefix(prefix, id) {
+			return nil
+		}
+	}
+	for _, glob := range a.AllowedGlobs {
+		if MatchGlob(glob, id) {
+			return nil
+		}
+	}
+	for _, re := range a.AllowedRegexps {
+		if re.MatchString(id) {
+			return nil
+		}
+	}
+	return errors.New("SPIFFE ID not allowed")
+}
+
+// trustDomain returns the lowercased trust domain of a SPIFFE ID, or of a
+// bare trust domain.
+func trustDomain(id string) string {
+	td := strings.ToLower(strings.TrimPrefix(id, "spiffe://"))
+	td, _, _ = strings.Cut(td, "/")
+	return td
+}
+
+// normalizeID decodes percent-encoded unreserved characters and strips a
+// single trailing slash so IDs compare equal regardless of how the issuer
+// encoded them. Prefixes and globs are only decoded: stripping their trailing
+// slash would widen them.
+func normalizeID(id string) string {
+	id = unescape(id)
+	if strings.HasSuffix(id, "/") && !strings.HasSuffix(id, "://") {
+		id = id[:len(id)-1]
+	}
+	return id
+}
+
+// unescape decodes percent-encoded unreserved characters (RFC 3986 section
+// 2.3) and upper-cases the hex digits of the remaining escapes. Reserved
+// characters stay encoded: decoding %2F would turn the single segment
+// "x%2Fadmin" into the two segments "x/admin" and let it match rules
+// written for another identity.
+func unescape(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// hasSPIFFEURI reports whether cert carries any spiffe:// URI SAN, well-formed
+// or not.
+func hasSPIFFEURI(cert *x509.Certificate) bool {
+	return countSPIFFEURIs(cert) > 0
+}
+
+// countSPIFFEURIs counts cert's spiffe:// URI SANs, well-formed or not.
+func countSPIFFEURIs(cert *x509.Certificate) int {
+	n := 0
+	for _, uri := range cert.URIs {
+		if uri != nil && uri.Scheme == "spiffe" {
+			n++
+		}
+	}
+	return n
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports whether id matches pattern with the rules of
+// Authorizer.AllowedGlobs, e.g. for SPIFFE IDs carried in request metadata:
+//
+//   - '+' matches exactly one non-empty path segment.
+//   - '*' is only allowed as the final character and matches any remaining
+//     segments, including none.
+//   - Other segments must match exactly; matching is case-sensitive.
+//
+// Percent-encoded unreserved characters in pattern and id are decoded first,
+// so %2F never matches a '/', and a trailing slash on id is ignored. A malformed pattern matches nothing.
+func MatchGlob(pattern, id string) bool {
+	return matchGlob(unescape(pattern), normalizeID(id))
+}
+
+// MatchPrefix reports whether id starts with prefix with the rules of
+// Authorizer.AllowedPrefixes. Percent-encoded unreserved characters are
+// decoded first, so %2F never matches a '/', and a trailing slash on id is
+// ignored, so include the separator in prefix ("spiffe://corp/prod/") to
+// avoid matching sibling paths such as "spiffe://corp/production".
+func MatchPrefix(prefix, id string) bool {
+	return strings.HasPrefix(normalizeID(id), unescape(prefix))
+}
+
 func matchGlob(pattern, value string) bool {
 	// Glob rules:
 	// - '*' is only allowed at the end and matches any remaining path segments.
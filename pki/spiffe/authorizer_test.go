@@ -1,9 +1,18 @@
 package spiffe
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"net/url"
+	"regexp"
 	"testing"
+	"time"
 )
 
 func TestAuthorizerVerifyPeerCertificate(t *testing.T) {
@@ -49,6 +58,237 @@ func TestAuthorizerRejectsNonSpiffeURIs(t *testing.T) {
 	}
 }
 
+func TestAuthorizerAllow(t *testing.T) {
+	t.Parallel()
+
+	auth := Authorizer{
+		AllowedPrefixes: []string{"spiffe://corp/prod/stack/payments/"},
+	}
+	if err := auth.Allow("spiffe://corp/prod/stack/payments/service/api"); err != nil {
+		t.Fatalf("expected prefix match to pass: %v", err)
+	}
+	if err := auth.Allow("spiffe://corp/prod/stack/billing/service/api"); err == nil {
+		t.Fatal("expected unmatched ID to be rejected")
+	}
+	if err := auth.Allow("https://corp/prod/stack/payments/service/api"); err == nil {
+		t.Fatal("expected non-spiffe ID to be rejected")
+	}
+}
+
+func TestAuthorizerSameTrustDomainAs(t *testing.T) {
+	t.Parallel()
+
+	for _, self := range []string{"spiffe://corp/prod/svc/api", "corp", "CORP"} {
+		auth := Authorizer{SameTrustDomainAs: self}
+		if err := auth.Allow("spiffe://corp/dev/svc/worker"); err != nil {
+			t.Fatalf("SameTrustDomainAs %q: expected same trust domain to pass: %v", self, err)
+		}
+		for _, id := range []string{"spiffe://corp.evil/prod/svc", "spiffe://partner/prod/svc", "spiffe://corpx"} {
+			if err := auth.Allow(id); err == nil {
+				t.Fatalf("SameTrustDomainAs %q: expected %s to be rejected", self, id)
+			}
+		}
+	}
+
+	cert := &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://corp/prod/db")}}
+	auth := Authorizer{SameTrustDomainAs: "spiffe://corp/prod/svc/api", AllowedExact: []string{"spiffe://partner/billing"}}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{cert}}); err != nil {
+		t.Fatalf("expected peer in the same trust domain to pass: %v", err)
+	}
+	if err := (Authorizer{}).Allow("spiffe://corp/prod/db"); err == nil {
+		t.Fatal("expected an empty SameTrustDomainAs to authorize nothing")
+	}
+}
+
+func TestAuthorizerAllowedRegexps(t *testing.T) {
+	t.Parallel()
+
+	auth := Authorizer{AllowedRegexps: []*regexp.Regexp{
+		regexp.MustCompile(`^spiffe://corp/(prod|staging)/svc/[a-z]+$`),
+	}}
+	for _, c := range []struct {
+		id    string
+		match bool
+	}{
+		{"spiffe://corp/prod/svc/api", true},
+		{"spiffe://corp/staging/svc/worker/", true},
+		{"spiffe://corp/staging/svc/%61pi", true},
+		{"spiffe://corp/prod/svc/api/canary", false},
+		{"spiffe://corp/dev/svc/api", false},
+		{"spiffe://corp.evil/prod/svc/api", false},
+		{"https://corp/prod/svc/api", false},
+	} {
+		if err := auth.Allow(c.id); (err == nil) != c.match {
+			t.Errorf("Allow(%q) = %v, want match %v", c.id, err, c.match)
+		}
+	}
+
+	cert := &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://corp/prod/svc/api")}}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{cert}}); err != nil {
+		t.Fatalf("expected peer matching a regexp to pass: %v", err)
+	}
+
+	corpCert, corpKey := newTestCA(t, "corp")
+	partnerCert, _ := newTestCA(t, "partner")
+	corpPool := x509.NewCertPool()
+	corpPool.AddCert(corpCert)
+	partnerPool := x509.NewCertPool()
+	partnerPool.AddCert(partnerCert)
+	federated := Authorizer{
+		AllowedRegexps:   []*regexp.Regexp{regexp.MustCompile(`^spiffe://partner/admin$`)},
+		FederatedBundles: map[string]*x509.CertPool{"corp": corpPool, "partner": partnerPool},
+	}
+	smuggled := newTestLeaf(t, corpCert, corpKey, "spiffe://corp/workload", "spiffe://partner/admin")
+	if err := federated.VerifyPeerCertificate([][]byte{smuggled}, nil); err == nil {
+		t.Fatal("expected regexp not to admit a trust domain the chain was not verified for")
+	}
+}
+
+func TestAuthorizerRequireSCT(t *testing.T) {
+	t.Parallel()
+
+	id := mustURL(t, "spiffe://corp/prod/stack/payments/service/api")
+	auth := Authorizer{
+		AllowedPrefixes: []string{"spiffe://corp/prod/stack/payments/"},
+		RequireSCT:      true,
+	}
+
+	without := &x509.Certificate{URIs: []*url.URL{id}}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{without}}); err == nil {
+		t.Fatal("expected leaf without SCTs to be rejected")
+	}
+
+	with := &x509.Certificate{
+		URIs:       []*url.URL{id},
+		Extensions: []pkix.Extension{{Id: oidSCTList, Value: []byte{0x04, 0x00}}},
+	}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{with}}); err != nil {
+		t.Fatalf("expected leaf with SCTs to pass: %v", err)
+	}
+}
+
+func TestAuthorizerAllowedPublicKeyAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	id := mustURL(t, "spiffe://corp/prod/svc")
+	auth := Authorizer{
+		AllowedExact:               []string{"spiffe://corp/prod/svc"},
+		AllowedPublicKeyAlgorithms: []x509.PublicKeyAlgorithm{x509.Ed25519},
+	}
+	ed := &x509.Certificate{URIs: []*url.URL{id}, PublicKeyAlgorithm: x509.Ed25519}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{ed}}); err != nil {
+		t.Fatalf("expected Ed25519 leaf to pass: %v", err)
+	}
+	rsaLeaf := &x509.Certificate{URIs: []*url.URL{id}, PublicKeyAlgorithm: x509.RSA}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{rsaLeaf}}); err == nil {
+		t.Fatal("expected RSA leaf to be rejected by an Ed25519-only policy")
+	}
+
+	auth.AllowedPublicKeyAlgorithms = nil
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{rsaLeaf}}); err != nil {
+		t.Fatalf("expected any algorithm without a policy: %v", err)
+	}
+}
+
+func TestAuthorizerStrictChain(t *testing.T) {
+	t.Parallel()
+
+	leaf := &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://corp/prod/svc")}}
+	smuggler := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "Rogue Intermediate"},
+		URIs:    []*url.URL{mustURL(t, "spiffe://corp/admin")},
+	}
+	root := &x509.Certificate{Subject: pkix.Name{CommonName: "Root"}}
+	chains := [][]*x509.Certificate{{leaf, smuggler, root}}
+
+	auth := Authorizer{AllowedExact: []string{"spiffe://corp/prod/svc"}}
+	if err := auth.VerifyPeerCertificate(nil, chains); err != nil {
+		t.Fatalf("expected non-strict mode to match the leaf only: %v", err)
+	}
+	auth.StrictChain = true
+	if err := auth.VerifyPeerCertificate(nil, chains); err == nil {
+		t.Fatal("expected SPIFFE URI on an intermediate to be rejected in strict mode")
+	}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{leaf, root}}); err != nil {
+		t.Fatalf("expected clean chain to pass strict mode: %v", err)
+	}
+}
+
+func TestAuthorizerRequireSingleURI(t *testing.T) {
+	t.Parallel()
+
+	single := &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://corp/prod/svc")}}
+	double := &x509.Certificate{URIs: []*url.URL{
+		mustURL(t, "spiffe://corp/dev/untrusted"),
+		mustURL(t, "spiffe://corp/prod/svc"),
+	}}
+	auth := Authorizer{AllowedExact: []string{"spiffe://corp/prod/svc"}}
+
+	for _, tc := range []struct {
+		name    string
+		strict  bool
+		leaf    *x509.Certificate
+		wantErr bool
+	}{
+		{"lenient single", false, single, false},
+		{"lenient double", false, double, false},
+		{"strict single", true, single, false},
+		{"strict double", true, double, true},
+	} {
+		auth.RequireSingleURI = tc.strict
+		err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{tc.leaf}})
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: VerifyPeerCertificate() = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestAuthorizerNormalizesIDs(t *testing.T) {
+	t.Parallel()
+
+	auth := Authorizer{
+		AllowedExact:    []string{"spiffe://corp/prod/svc"},
+		AllowedPrefixes: []string{"spiffe://corp/stage/"},
+	}
+	cases := []struct {
+		id    string
+		allow bool
+	}{
+		{"spiffe://corp/prod/svc/", true},
+		{"spiffe://corp/prod/sv%63", true},
+		{"spiffe://corp/prod/svc//", false},
+		{"spiffe://corp/stage/svc", true},
+		{"spiffe://corp/stage%2Fsvc", false},
+		{"spiffe://corp/stage/sv%63", true},
+		{"spiffe://corp/stagevc", false},
+	}
+	for _, c := range cases {
+		if err := auth.Allow(c.id); (err == nil) != c.allow {
+			t.Fatalf("Allow(%q) = %v, want allow=%v", c.id, err, c.allow)
+		}
+	}
+
+	slash := Authorizer{
+		AllowedExact:    []string{"spiffe://b/x/admin"},
+		AllowedPrefixes: []string{"spiffe://b/x/"},
+		AllowedGlobs:    []string{"spiffe://b/x/+"},
+	}
+	for _, id := range []string{"spiffe://b/x%2Fadmin", "spiffe://b/x%2fadmin"} {
+		if err := slash.Allow(id); err == nil {
+			t.Fatalf("Allow(%q) matched a rule for a '/'; %%2F must stay in its segment", id)
+		}
+	}
+	encoded := Authorizer{AllowedExact: []string{"spiffe://b/x%2fadmin"}}
+	if err := encoded.Allow("spiffe://b/x%2Fadmin"); err != nil {
+		t.Fatalf("expected escapes to compare case-insensitively: %v", err)
+	}
+
+	pattern := Authorizer{AllowedExact: []string{"spiffe://corp/prod/svc/"}}
+	if err := pattern.Allow("spiffe://corp/prod/svc"); err != nil {
+		t.Fatalf("expected trailing slash in pattern to be normalized: %v", err)
+	}
+}
+
 func TestMatchGlobEdgeCases(t *testing.T) {
 	t.Parallel()
 
@@ -73,6 +313,244 @@ func TestMatchGlobEdgeCases(t *testing.T) {
 	}
 }
 
+func TestMatchGlobAndPrefix(t *testing.T) {
+	t.Parallel()
+
+	globs := []struct {
+		pattern, id string
+		match       bool
+	}{
+		{"spiffe://corp/+/svc/*", "spiffe://corp/prod/svc/api/v1", true},
+		{"spiffe://corp/+/svc/*", "spiffe://corp/prod/svc", true},
+		{"spiffe://corp/+/svc", "spiffe://corp/prod/svc/", true}, // trailing slash on id ignored
+		{"spiffe://corp/+/svc", "spiffe://corp/pr%6Fd/sv%63", true},
+		{"spiffe://corp/pr%6Fd/*", "spiffe://corp/prod/api", true},
+		{"spiffe://corp/+/svc", "spiffe://corp/prod/stage/svc", false},
+		{"spiffe://corp/+/svc", "spiffe://Corp/prod/svc", false},
+	}
+	for _, c := range globs {
+		if got := MatchGlob(c.pattern, c.id); got != c.match {
+			t.Fatalf("MatchGlob(%q, %q) = %v, want %v", c.pattern, c.id, got, c.match)
+		}
+		// Allow must agree with the exported matcher.
+		if err := (Authorizer{AllowedGlobs: []string{c.pattern}}).Allow(c.id); (err == nil) != c.match {
+			t.Fatalf("Allow(%q) with glob %q = %v, want match %v", c.id, c.pattern, err, c.match)
+		}
+	}
+
+	prefixes := []struct {
+		prefix, id string
+		match      bool
+	}{
+		{"spiffe://corp/prod/", "spiffe://corp/prod/svc", true},
+		{"spiffe://corp/prod/", "spiffe://corp/production/svc", false},
+		{"spiffe://corp/prod", "spiffe://corp/production/svc", true},
+		{"spiffe://corp/pr%6Fd/", "spiffe://corp/prod/svc", true},
+		{"spiffe://corp/prod/", "spiffe://corp/prod/", false}, // trailing slash stripped from id
+	}
+	for _, c := range prefixes {
+		if got := MatchPrefix(c.prefix, c.id); got != c.match {
+			t.Fatalf("MatchPrefix(%q, %q) = %v, want %v", c.prefix, c.id, got, c.match)
+		}
+		if err := (Authorizer{AllowedPrefixes: []string{c.prefix}}).Allow(c.id); (err == nil) != c.match {
+			t.Fatalf("Allow(%q) with prefix %q = %v, want match %v", c.id, c.prefix, err, c.match)
+		}
+	}
+}
+
+func TestIDsFromCert(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		uris []string
+		want []string
+	}{
+		{"none", nil, nil},
+		{"non-spiffe only", []string{"https://corp/svc"}, nil},
+		{"one", []string{"https://corp/svc", "spiffe://corp/prod/svc"}, []string{"spiffe://corp/prod/svc"}},
+		{"multiple", []string{"spiffe://corp/prod/a", "spiffe://partner/b"}, []string{"spiffe://corp/prod/a", "spiffe://partner/b"}},
+		{"malformed", []string{"spiffe:///svc", "spiffe://corp:8443/svc", "spiffe://corp/svc?x=1", "spiffe://corp/svc#f", "spiffe://u@corp/svc"}, nil},
+	}
+	for _, c := range cases {
+		cert := &x509.Certificate{}
+		for _, raw := range c.uris {
+			cert.URIs = append(cert.URIs, mustURL(t, raw))
+		}
+		got := IDsFromCert(cert)
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: IDsFromCert = %v, want %v", c.name, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%s: IDsFromCert = %v, want %v", c.name, got, c.want)
+			}
+		}
+	}
+	if IDsFromCert(nil) != nil {
+		t.Fatal("expected nil cert to yield no IDs")
+	}
+}
+
+func TestAuthorizerFederatedBundles(t *testing.T) {
+	t.Parallel()
+
+	corpCert, corpKey := newTestCA(t, "corp")
+	partnerCert, partnerKey := newTestCA(t, "partner")
+	corpPool := x509.NewCertPool()
+	corpPool.AddCert(corpCert)
+	partnerPool := x509.NewCertPool()
+	partnerPool.AddCert(partnerCert)
+
+	auth := Authorizer{
+		AllowedPrefixes: []string{"spiffe://corp/prod/", "spiffe://partner/billing/"},
+		FederatedBundles: map[string]*x509.CertPool{
+			"corp":    corpPool,
+			"partner": partnerPool,
+		},
+	}
+
+	cases := []struct {
+		name  string
+		leaf  []byte
+		allow bool
+	}{
+		{"corp leaf from corp CA", newTestLeaf(t, corpCert, corpKey, "spiffe://corp/prod/api"), true},
+		{"partner leaf from partner CA", newTestLeaf(t, partnerCert, partnerKey, "spiffe://partner/billing/api"), true},
+		{"partner ID signed by corp CA", newTestLeaf(t, corpCert, corpKey, "spiffe://partner/billing/api"), false},
+		{"corp ID signed by partner CA", newTestLeaf(t, partnerCert, partnerKey, "spiffe://corp/prod/api"), false},
+		{"chain ok but ID not allowed", newTestLeaf(t, corpCert, corpKey, "spiffe://corp/stage/api"), false},
+		{"unknown trust domain", newTestLeaf(t, corpCert, corpKey, "spiffe://other/prod/api"), false},
+		{"partner ID smuggled after corp ID", newTestLeaf(t, corpCert, corpKey, "spiffe://corp/stage/api", "spiffe://partner/billing/api"), false},
+		{"corp ID after unmatched corp ID", newTestLeaf(t, corpCert, corpKey, "spiffe://corp/stage/api", "spiffe://corp/prod/api"), true},
+	}
+	for _, c := range cases {
+		if err := auth.VerifyPeerCertificate([][]byte{c.leaf}, nil); (err == nil) != c.allow {
+			t.Fatalf("%s: VerifyPeerCertificate = %v, want allow=%v", c.name, err, c.allow)
+		}
+	}
+	if err := auth.VerifyPeerCertificate(nil, nil); err == nil {
+		t.Fatal("expected missing peer certificate to be rejected")
+	}
+}
+
+func TestAuthorizerPinnedLeaf(t *testing.T) {
+	t.Parallel()
+
+	caCert, caKey := newTestCA(t, "corp")
+	admin := newTestLeaf(t, caCert, caKey, "spiffe://corp/admin/breakglass")
+	other := newTestLeaf(t, caCert, caKey, "spiffe://corp/admin/other")
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	auth := Authorizer{
+		AllowedPrefixes:  []string{"spiffe://corp/prod/"},
+		PinnedLeafSHA256: [][sha256.Size]byte{sha256.Sum256(admin)},
+	}
+	adminCert, err := x509.ParseCertificate(admin)
+	if err != nil {
+		t.Fatalf("parse admin cert: %v", err)
+	}
+	if err := auth.VerifyPeerCertificate(nil, [][]*x509.Certificate{{adminCert, caCert}}); err != nil {
+		t.Fatalf("expected pinned leaf to bypass SPIFFE rules: %v", err)
+	}
+	if err := auth.VerifyPeerCertificate([][]byte{other}, nil); err == nil {
+		t.Fatal("expected unpinned leaf outside the rules to be rejected")
+	}
+
+	auth.FederatedBundles = map[string]*x509.CertPool{"partner": pool}
+	if err := auth.VerifyPeerCertificate([][]byte{admin}, nil); err != nil {
+		t.Fatalf("expected pinned leaf to bypass federated verification: %v", err)
+	}
+}
+
+func TestAuthorizerVerifyFromRaw(t *testing.T) {
+	t.Parallel()
+
+	caCert, caKey := newTestCA(t, "corp")
+	rogueCert, rogueKey := newTestCA(t, "rogue")
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	good := newTestLeaf(t, caCert, caKey, "spiffe://corp/prod/api")
+	rogue := newTestLeaf(t, rogueCert, rogueKey, "spiffe://corp/prod/api")
+
+	rules := Authorizer{AllowedPrefixes: []string{"spiffe://corp/prod/"}}
+	if err := rules.VerifyPeerCertificate([][]byte{good}, nil); err == nil {
+		t.Fatal("expected empty verifiedChains to be rejected without VerifyFromRaw")
+	}
+
+	raw := rules
+	raw.VerifyFromRaw = true
+	raw.Roots = pool
+	if err := raw.VerifyPeerCertificate([][]byte{good}, nil); err != nil {
+		t.Fatalf("expected raw chain from trusted CA to pass: %v", err)
+	}
+	if err := raw.VerifyPeerCertificate([][]byte{rogue}, nil); err == nil {
+		t.Fatal("expected raw chain from untrusted CA to be rejected")
+	}
+	stage := newTestLeaf(t, caCert, caKey, "spiffe://corp/stage/api")
+	if err := raw.VerifyPeerCertificate([][]byte{stage}, nil); err == nil {
+		t.Fatal("expected SPIFFE rules to apply after raw verification")
+	}
+
+	raw.Roots = nil
+	if err := raw.VerifyPeerCertificate([][]byte{good}, nil); err == nil {
+		t.Fatal("expected VerifyFromRaw without Roots to be rejected")
+	}
+}
+
+func newTestCA(t *testing.T, name string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name + " CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+	return cert, key
+}
+
+func newTestLeaf(t *testing.T, ca *x509.Certificate, caKey crypto.Signer, ids ...string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate leaf key: %v", err)
+	}
+	var uris []*url.URL
+	for _, id := range ids {
+		uris = append(uris, mustURL(t, id))
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		URIs:         uris,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+	return der
+}
+
 func mustURL(t *testing.T, raw string) *url.URL {
 	t.Helper()
 	u, err := url.Parse(raw)
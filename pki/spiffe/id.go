@@ -0,0 +1,92 @@
+package spiffe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ID is a SPIFFE ID split into its trust domain and path, e.g. for building
+// URI SANs without hand-formatting strings:
+//
+//	spiffe.ID{TrustDomain: "corp", Path: "/prod/svc"}.String()
+//
+// The zero value is not a valid ID. Use Parse to read and validate one.
+type ID struct {
+	// TrustDomain is the lowercase trust domain name, e.g. "corp".
+	TrustDomain string
+	// Path is empty or a "/"-prefixed path without a trailing slash.
+	Path string
+}
+
+// Parse parses and validates a SPIFFE ID per the SPIFFE ID spec: the scheme
+// is "spiffe", the trust domain holds only lowercase letters, digits, '.',
+// '-' and '_', and each path segment is non-empty, not "." or "..", and holds
+// only letters, digits, '.', '-' and '_'. Ports, user info, queries,
+// fragments and percent-encoding are rejected.
+func Parse(s string) (ID, error) {
+	rest, ok := strings.CutPrefix(s, "spiffe://")
+	if !ok {
+		return ID{}, fmt.Errorf("spiffe ID %q: scheme must be spiffe://", s)
+	}
+	td, path := rest, ""
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		td, path = rest[:i], rest[i:]
+	}
+	id := ID{TrustDomain: td, Path: path}
+	if err := id.Validate(); err != nil {
+		return ID{}, fmt.Errorf("spiffe ID %q: %w", s, err)
+	}
+	return id, nil
+}
+
+// Validate reports whether id satisfies the rules Parse enforces.
+func (id ID) Validate() error {
+	if id.TrustDomain == "" {
+		return errors.New("trust domain is empty")
+	}
+	for _, c := range id.TrustDomain {
+		if !isTrustDomainChar(c) {
+			return fmt.Errorf("trust domain has invalid character %q", c)
+		}
+	}
+	if id.Path == "" {
+		return nil
+	}
+	if id.Path[0] != '/' {
+		return errors.New(`path must start with "/"`)
+	}
+	for _, seg := range strings.Split(id.Path[1:], "/") {
+		switch seg {
+		case "":
+			return errors.New("path has an empty segment")
+		case ".", "..":
+			return fmt.Errorf("path has a %q segment", seg)
+		}
+		for _, c := range seg {
+			if !isPathChar(c) {
+				return fmt.Errorf("path has invalid character %q", c)
+			}
+		}
+	}
+	return nil
+}
+
+// String returns the ID in spiffe://<trust domain><path> form. It does not
+// validate id.
+func (id ID) String() string {
+	return "spiffe://" + id.TrustDomain + id.Path
+}
+
+// MemberOf reports whether id belongs to trustDomain.
+func (id ID) MemberOf(trustDomain string) bool {
+	return id.TrustDomain == trustDomain
+}
+
+func isTrustDomainChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_'
+}
+
+func isPathChar(c rune) bool {
+	return isTrustDomainChar(c) || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,83 @@
+package spiffe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseID(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in   string
+		want ID
+	}{
+		{"spiffe://corp", ID{TrustDomain: "corp"}},
+		{"spiffe://corp/prod/svc", ID{TrustDomain: "corp", Path: "/prod/svc"}},
+		{"spiffe://example.org/ns/Default_1/sa/api-v2.1", ID{TrustDomain: "example.org", Path: "/ns/Default_1/sa/api-v2.1"}},
+		{"spiffe://my-domain_1.io/a", ID{TrustDomain: "my-domain_1.io", Path: "/a"}},
+	} {
+		got, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Fatalf("Parse(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+		if got.String() != tc.in {
+			t.Fatalf("String() = %q, want %q", got.String(), tc.in)
+		}
+	}
+
+	for _, tc := range []struct {
+		in, wantErr string
+	}{
+		{"", "scheme"},
+		{"corp/prod/svc", "scheme"},
+		{"SPIFFE://corp/svc", "scheme"},
+		{"https://corp/svc", "scheme"},
+		{"spiffe:/corp/svc", "scheme"},
+		{"spiffe://", "trust domain is empty"},
+		{"spiffe:///svc", "trust domain is empty"},
+		{"spiffe://Corp/svc", "trust domain has invalid character"},
+		{"spiffe://corp:8443/svc", "trust domain has invalid character"},
+		{"spiffe://u@corp/svc", "trust domain has invalid character"},
+		{"spiffe://corp?x=1", "trust domain has invalid character"},
+		{"spiffe://corp/", "empty segment"},
+		{"spiffe://corp/prod/", "empty segment"},
+		{"spiffe://corp//svc", "empty segment"},
+		{"spiffe://corp/./svc", `"." segment`},
+		{"spiffe://corp/prod/..", `".." segment`},
+		{"spiffe://corp/svc?x=1", "invalid character"},
+		{"spiffe://corp/svc#f", "invalid character"},
+		{"spiffe://corp/sv%63", "invalid character"},
+		{"spiffe://corp/s vc", "invalid character"},
+		{"spiffe://corp/*", "invalid character"},
+	} {
+		_, err := Parse(tc.in)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("Parse(%q) = %v, want error containing %q", tc.in, err, tc.wantErr)
+		}
+	}
+}
+
+func TestIDBuild(t *testing.T) {
+	t.Parallel()
+
+	id := ID{TrustDomain: "corp", Path: "/prod/svc"}
+	if got := id.String(); got != "spiffe://corp/prod/svc" {
+		t.Fatalf("String() = %q", got)
+	}
+	if err := id.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	if !id.MemberOf("corp") || id.MemberOf("partner") {
+		t.Fatal("unexpected MemberOf result")
+	}
+	if err := (ID{TrustDomain: "corp", Path: "prod"}).Validate(); err == nil {
+		t.Fatal("expected a relative path to be rejected")
+	}
+	if err := (ID{}).Validate(); err == nil {
+		t.Fatal("expected the zero ID to be invalid")
+	}
+}
@@ -0,0 +1,57 @@
+package spiffe
+
+import (
+	"crypto/x509"
+	"sync/atomic"
+)
+
+// ReloadableAuthorizer holds an Authorizer that can be replaced while
+// handshakes are verifying against it, e.g. when rules come from a watched
+// config file. Each call uses a consistent snapshot of the rules in effect
+// when it started. The zero value rejects every peer until Store is called.
+type ReloadableAuthorizer struct {
+	curr atomic.Pointer[Authorizer]
+}
+
+// NewReloadableAuthorizer returns a ReloadableAuthorizer serving auth.
+func NewReloadableAuthorizer(auth Authorizer) *ReloadableAuthorizer {
+	r := &ReloadableAuthorizer{}
+	r.Store(auth)
+	return r
+}
+
+// Store replaces the rules. auth is deep-copied, so the caller may keep
+// modifying its slices afterwards without racing in-flight verifications.
+func (r *ReloadableAuthorizer) Store(auth Authorizer) {
+	r.curr.Store(auth.clone())
+}
+
+// Load returns a copy of the current rules.
+func (r *ReloadableAuthorizer) Load() Authorizer {
+	if a := r.curr.Load(); a != nil {
+		return *a.clone()
+	}
+	return Authorizer{}
+}
+
+// VerifyPeerCertificate can be used as tls.Config.VerifyPeerCertificate.
+func (r *ReloadableAuthorizer) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return r.snapshot().VerifyPeerCertificate(rawCerts, verifiedChains)
+}
+
+// Allow applies the current rules to a bare SPIFFE ID.
+func (r *ReloadableAuthorizer) Allow(id string) error {
+	return r.snapshot().Allow(id)
+}
+
+// VerifiesRaw reports whether the current rules verify rawCerts themselves.
+func (r *ReloadableAuthorizer) VerifiesRaw() bool {
+	return r.snapshot().VerifiesRaw()
+}
+
+func (r *ReloadableAuthorizer) snapshot() *Authorizer {
+	if a := r.curr.Load(); a != nil {
+		return a
+	}
+	return &Authorizer{}
+}
@@ -0,0 +1,60 @@
+package spiffe
+
+import (
+	"crypto/x509"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestReloadableAuthorizerConcurrentReload(t *testing.T) {
+	t.Parallel()
+
+	cert := &x509.Certificate{URIs: []*url.URL{mustURL(t, "spiffe://corp/prod/svc")}}
+	chains := [][]*x509.Certificate{{cert}}
+
+	rules := Authorizer{AllowedPrefixes: []string{"spiffe://corp/prod/"}}
+	reloadable := NewReloadableAuthorizer(rules)
+	// Mutating the caller's slice after Store must not affect the stored rules.
+	rules.AllowedPrefixes[0] = "spiffe://corp/dev/"
+	if err := reloadable.VerifyPeerCertificate(nil, chains); err != nil {
+		t.Fatalf("expected stored rules to be isolated from the caller: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				// Either rule set allows the peer, so every call must pass.
+				if err := reloadable.VerifyPeerCertificate(nil, chains); err != nil {
+					t.Errorf("VerifyPeerCertificate failed during reload: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shared := Authorizer{AllowedPrefixes: []string{"spiffe://corp/"}}
+		for i := range 200 {
+			if i%2 == 0 {
+				reloadable.Store(shared)
+			} else {
+				reloadable.Store(Authorizer{AllowedExact: []string{"spiffe://corp/prod/svc"}})
+			}
+			shared.AllowedPrefixes[0] = "spiffe://corp/"
+		}
+	}()
+	wg.Wait()
+
+	reloadable.Store(Authorizer{AllowedExact: []string{"spiffe://corp/other"}})
+	if err := reloadable.VerifyPeerCertificate(nil, chains); err == nil {
+		t.Fatal("expected the reloaded rules to reject the peer")
+	}
+	if err := (&ReloadableAuthorizer{}).Allow("spiffe://corp/prod/svc"); err == nil {
+		t.Fatal("expected the zero value to reject every peer")
+	}
+}
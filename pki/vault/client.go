@@ -7,32 +7,155 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// Token lifecycle events passed to Client.OnToken.
+const (
+	TokenLogin       = "login"
+	TokenRelogin     = "relogin"
+	TokenInvalidated = "invalidate"
+)
+
 var (
-	ErrAuthRequired = errors.New("vault auth required")
+	ErrAuthRequired  = errors.New("vault auth required")
+	ErrSealed        = errors.New("vault is sealed")
+	ErrUninitialized = errors.New("vault is not initialized")
+	ErrStandby       = errors.New("vault node is in standby")
+	// ErrWrapExpired reports that a wrapped SecretID or issue response could
+	// not be unwrapped because its wrapping token expired or was already
+	// used. A wrapped SecretID must be re-issued; a wrapped issue response
+	// is lost, and the next attempt issues a new cert.
+	ErrWrapExpired = errors.New("vault wrapping token expired or already used")
 )
 
+// wrapInvalidMessage is Vault's error for an expired, used or unknown
+// wrapping token.
+const wrapInvalidMessage = "wrapping token is not valid or does not exist"
+
+// standbyMessages are Vault error fragments returned when a standby node
+// cannot serve a request locally.
+var standbyMessages = []string{
+	"local node not active",
+	"node not active but active cluster node not found",
+	"performance standby",
+	"cannot write to readonly storage",
+}
+
 type Client struct {
-	Addr      string
+	Addr string
+	// Addrs lists additional nodes of the same cluster. Issuance that fails
+	// with ErrStandby is retried against them, and the node that succeeds is
+	// tried first next time.
+	Addrs     []string
 	Namespace string
-	Token     string
+	// Token is the first of the token sources tried when no AppRole
+	// credentials are configured. Like the Vault CLI, the client then falls
+	// back, in order, to TokenFile, the VAULT_TOKEN environment variable and
+	// the token helper file ~/.vault-token. VAULT_TOKEN comes before
+	// ~/.vault-token because the Vault CLI gives it precedence; an exported
+	// VAULT_TOKEN therefore wins over a stale helper file, as it does for
+	// the vault command. Sources other than Token are
+	// re-read whenever the token is invalidated, so a rotated token sink
+	// (e.g. one maintained by Vault Agent) is picked up.
+	Token string
+	// TokenFile holds a token, e.g. a Vault Agent sink.
+	TokenFile string
 
 	RoleID   string
 	SecretID string
-	AuthPath string // default: auth/approle/login
+	// RoleIDFile and SecretIDFile are re-read on every login so rotated
+	// credentials are picked up. They take precedence over RoleID/SecretID.
+	RoleIDFile   string
+	SecretIDFile string
+	AuthPath     string // default: auth/approle/login
+	// SecretIDWrapped treats SecretID (or SecretIDFile's contents) as a
+	// response-wrapping token that is unwrapped via sys/wrapping/unwrap
+	// before login. Wrapping tokens are single-use, so the unwrapped SecretID
+	// is kept for relogins until a different wrapping token appears. An
+	// expired or used wrapping token fails with ErrWrapExpired.
+	SecretIDWrapped bool
+	// DisableAuthRetry surfaces a 401/403 from issue or sign immediately as an
+	// AuthError, instead of logging in again via AppRole and retrying once.
+	// Use it where a rejection means the role was revoked rather than that
+	// the token expired.
+	DisableAuthRetry bool
+	// ReloginBefore re-authenticates via AppRole this long before a known
+	// token expiry instead of waiting for an auth error. Zero disables it.
+	ReloginBefore time.Duration
+	// OnToken is a best-effort notification hook for token lifecycle events,
+	// e.g. to correlate with Vault audit logs. It runs asynchronously with
+	// TokenLogin, TokenRelogin or TokenInvalidated and the token's remaining
+	// TTL (zero if unknown or invalidated). The client re-logs in rather than
+	// renewing tokens, so proactive refreshes are reported as TokenRelogin.
+	OnToken func(event string, ttl time.Duration)
+	// Metrics, if set, counts AppRole logins, login failures and proactive
+	// token refreshes. See PrometheusMetrics.
+	Metrics ClientMetrics
 
 	HTTPClient *http.Client
 
-	mu sync.RWMutex
+	// UserAgent and Headers are applied to every request. Content-Type,
+	// X-Vault-Namespace and X-Vault-Token are set by the client and take
+	// precedence over Headers; UserAgent takes precedence over a User-Agent
+	// entry in Headers.
+	UserAgent string
+	Headers   http.Header
+
+	// Transport tuning for the pooled client built when HTTPClient is nil.
+	Timeout             time.Duration // default: 10s
+	MaxIdleConnsPerHost int           // default: 4
+	IdleConnTimeout     time.Duration // default: 90s
+
+	mu          sync.RWMutex
+	activeAddr  string
+	authMethod  string
+	tokenExpiry time.Time
+	renewable   bool
+
+	wrapToken       string // wrapping token unwrappedSecret came from
+	unwrappedSecret string
+
+	clientOnce sync.Once
+	client     *http.Client
 }
 
 func (c *Client) Issue(ctx context.Context, pkiPath, role string, req IssueRequest) (*IssueResponse, error) {
+	return c.pkiWrite(ctx, pkiPath, "issue", role, req, true, req.WrapTTL)
+}
+
+// IssueRaw is like Issue but also returns the full decoded response body,
+// for fields IssueResponse does not model (e.g. data.serial_number,
+// data.expiration or warnings).
+func (c *Client) IssueRaw(ctx context.Context, pkiPath, role string, req IssueRequest) (*IssueResponse, map[string]any, error) {
+	resp, err := c.Issue(ctx, pkiPath, role, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, resp.raw, nil
+}
+
+// Sign submits a CSR to v1/<pkiPath>/sign/<role>, for keys that never leave
+// the caller (e.g. HSM-backed signers). The response has no PrivateKey.
+func (c *Client) Sign(ctx context.Context, pkiPath, role string, req SignRequest) (*IssueResponse, error) {
+	if req.CSR == "" {
+		return nil, errors.New("csr required")
+	}
+	return c.pkiWrite(ctx, pkiPath, "sign", role, req, false, req.WrapTTL)
+}
+
+// pkiWrite posts body to v1/<pkiPath>/<op>/<role>, failing over across
+// Addrs on ErrStandby. A positive wrapTTL has Vault wrap the response, which
+// is then unwrapped from the same node.
+func (c *Client) pkiWrite(ctx context.Context, pkiPath, op, role string, body any, requireKey bool, wrapTTL time.Duration) (*IssueResponse, error) {
 	if c.Addr == "" {
 		return nil, errors.New("vault addr required")
 	}
@@ -47,34 +170,221 @@ func (c *Client) Issue(ctx context.Context, pkiPath, role string, req IssueReque
 		return nil, err
 	}
 
-	endpoint := c.url(path.Join("v1", pkiPath, "issue", role))
-	resp, err := c.doJSON(ctx, http.MethodPost, endpoint, req, true)
+	var err error
+	for _, addr := range c.addrs() {
+		var resp *IssueResponse
+		resp, err = c.pkiWriteAt(ctx, addr, joinURL(addr, path.Join("v1", pkiPath, op, role)), body, requireKey, wrapTTL)
+		if err == nil {
+			c.mu.Lock()
+			c.activeAddr = addr
+			c.mu.Unlock()
+			return resp, nil
+		}
+		if !errors.Is(err, ErrStandby) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) pkiWriteAt(ctx context.Context, addr, endpoint string, body any, requireKey bool, wrapTTL time.Duration) (*IssueResponse, error) {
+	var header http.Header
+	if wrapTTL > 0 {
+		// Whole seconds, rounded up so a sub-second TTL still wraps.
+		header = http.Header{}
+		header.Set("X-Vault-Wrap-TTL", strconv.FormatInt(int64((wrapTTL+time.Second-1)/time.Second), 10)+"s")
+	}
+	post := func() (*http.Response, error) {
+		token := c.token()
+		if token == "" {
+			return nil, ErrAuthRequired
+		}
+		return c.doJSONWithToken(ctx, http.MethodPost, endpoint, body, token, header)
+	}
+
+	resp, err := post()
 	if err == nil {
-		return decodeIssue(resp)
+		return c.decodePKI(ctx, addr, resp, requireKey, wrapTTL > 0)
 	}
 
 	// If auth failed, retry once with fresh login.
-	if isAuthError(err) && c.RoleID != "" && c.SecretID != "" {
-		c.setToken("")
+	if isAuthError(err) && c.hasAppRole() && !c.DisableAuthRetry {
+		c.InvalidateToken()
 		if err := c.ensureToken(ctx); err != nil {
 			return nil, err
 		}
-		resp, err2 := c.doJSON(ctx, http.MethodPost, endpoint, req, true)
+		resp, err2 := post()
 		if err2 != nil {
 			return nil, err2
 		}
-		return decodeIssue(resp)
+		return c.decodePKI(ctx, addr, resp, requireKey, wrapTTL > 0)
 	}
 
 	return nil, err
 }
 
+// decodePKI decodes an issue or sign response. If wrapped, resp must carry a
+// response-wrapping token, which is unwrapped against addr to get the actual
+// response; a response Vault did not wrap is rejected, as its key has
+// already crossed the wire in the clear.
+func (c *Client) decodePKI(ctx context.Context, addr string, resp *http.Response, requireKey, wrapped bool) (*IssueResponse, error) {
+	if !wrapped {
+		return decodeIssue(resp, requireKey)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var out struct {
+		WrapInfo *struct {
+			Token string `json:"token"`
+		} `json:"wrap_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("vault wrapped issue response (http %d): decode: %w", resp.StatusCode, err)
+	}
+	if out.WrapInfo == nil || out.WrapInfo.Token == "" {
+		return nil, errors.New("vault issue response is not wrapped: X-Vault-Wrap-TTL was dropped or refused, e.g. by a proxy")
+	}
+
+	unwrapped, err := c.doJSONWithToken(ctx, http.MethodPost, joinURL(addr, "v1/sys/wrapping/unwrap"), nil, out.WrapInfo.Token, nil)
+	if err != nil {
+		var herr *HTTPError
+		if errors.As(err, &herr) && strings.Contains(herr.Body, wrapInvalidMessage) {
+			return nil, fmt.Errorf("vault unwrap issue response: %w: %w", ErrWrapExpired, err)
+		}
+		return nil, fmt.Errorf("vault unwrap issue response: %w", err)
+	}
+	return decodeIssue(unwrapped, requireKey)
+}
+
+// ListRoles returns the role names configured on the PKI mount at pkiPath.
+// A mount without roles yields an empty list.
+func (c *Client) ListRoles(ctx context.Context, pkiPath string) ([]string, error) {
+	if c.Addr == "" {
+		return nil, errors.New("vault addr required")
+	}
+	if pkiPath == "" {
+		return nil, errors.New("pki path required")
+	}
+	if err := c.ensureToken(ctx); err != nil {
+		return nil, err
+	}
+
+	endpoint := c.url(path.Join("v1", pkiPath, "roles")) + "?list=true"
+	resp, err := c.doJSON(ctx, http.MethodGet, endpoint, nil, true)
+	if err != nil {
+		if isNotFound(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var out struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if out.Data.Keys == nil {
+		return []string{}, nil
+	}
+	return out.Data.Keys, nil
+}
+
+// Health checks v1/sys/health and returns ErrSealed, ErrUninitialized or
+// ErrStandby when the node cannot serve issuance requests.
+func (c *Client) Health(ctx context.Context) error {
+	if c.Addr == "" {
+		return errors.New("vault addr required")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("v1/sys/health"), nil)
+	if err != nil {
+		return err
+	}
+	c.applyHeaders(req)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusTooManyRequests, 473: // standby, performance standby
+		return ErrStandby
+	case http.StatusNotImplemented:
+		return ErrUninitialized
+	case http.StatusServiceUnavailable:
+		return ErrSealed
+	default:
+		return fmt.Errorf("vault health http %d", resp.StatusCode)
+	}
+}
+
+// InvalidateToken clears the cached token so the next request logs in again,
+// e.g. after the AppRole credentials were rotated. Without AppRole
+// credentials, the next request re-reads the token sources after Token and
+// fails with ErrAuthRequired if none holds a token.
+func (c *Client) InvalidateToken() {
+	c.setToken("")
+	c.onToken(TokenInvalidated, 0)
+}
+
+func (c *Client) onToken(event string, ttl time.Duration) {
+	if c.OnToken == nil {
+		return
+	}
+	go c.OnToken(event, ttl)
+}
+
 func (c *Client) ensureToken(ctx context.Context) error {
-	if c.token() != "" {
+	if c.token() != "" && !c.reloginDue() {
 		return nil
 	}
-	if c.RoleID == "" || c.SecretID == "" {
-		return ErrAuthRequired
+	if !c.hasAppRole() {
+		token, err := c.discoverToken()
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			return ErrAuthRequired
+		}
+		c.setToken(token)
+		return nil
+	}
+	event, err := c.login(ctx)
+	if c.Metrics != nil {
+		switch {
+		case err != nil:
+			c.Metrics.LoginFailed(err)
+		case event == TokenRelogin:
+			c.Metrics.LoginSucceeded()
+			c.Metrics.TokenRefreshed()
+		default:
+			c.Metrics.LoginSucceeded()
+		}
+	}
+	return err
+}
+
+// login authenticates via AppRole and stores the token, returning whether it
+// was a TokenLogin or a TokenRelogin.
+func (c *Client) login(ctx context.Context) (string, error) {
+	roleID, err := readCredential(c.RoleID, c.RoleIDFile)
+	if err != nil {
+		return "", err
+	}
+	secretID, err := readCredential(c.SecretID, c.SecretIDFile)
+	if err != nil {
+		return "", err
+	}
+	if c.SecretIDWrapped {
+		if secretID, err = c.unwrapSecretID(ctx, secretID); err != nil {
+			return "", err
+		}
 	}
 
 	authPath := c.AuthPath
@@ -83,35 +393,167 @@ func (c *Client) ensureToken(ctx context.Context) error {
 	}
 	endpoint := c.url(path.Join("v1", authPath))
 	payload := map[string]string{
-		"role_id":   c.RoleID,
-		"secret_id": c.SecretID,
+		"role_id":   roleID,
+		"secret_id": secretID,
 	}
 	resp, err := c.doJSON(ctx, http.MethodPost, endpoint, payload, false)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	var out struct {
 		Auth struct {
-			ClientToken string `json:"client_token"`
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+			Renewable     bool   `json:"renewable"`
 		} `json:"auth"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return err
+		return "", err
 	}
 	if out.Auth.ClientToken == "" {
-		return errors.New("vault approle auth returned empty token")
+		return "", errors.New("vault approle auth returned empty token")
+	}
+
+	ttl := time.Duration(out.Auth.LeaseDuration) * time.Second
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	event := TokenLogin
+	if c.Token != "" {
+		event = TokenRelogin
+	}
+	c.Token = out.Auth.ClientToken
+	c.authMethod = "approle"
+	c.tokenExpiry = expiry
+	c.renewable = out.Auth.Renewable
+	c.mu.Unlock()
+	c.onToken(event, ttl)
+	return event, nil
+}
+
+// AuthInfo reports how the client authenticated and, for logins, when the
+// token expires. Method is "approle" after an AppRole login, "token" for a
+// caller-supplied token, or empty if unauthenticated. A zero expiry means
+// unknown or non-expiring.
+func (c *Client) AuthInfo() (method string, tokenExpiry time.Time, renewable bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.authMethod == "" && c.Token != "" {
+		return "token", time.Time{}, false
 	}
-	c.setToken(out.Auth.ClientToken)
-	return nil
+	return c.authMethod, c.tokenExpiry, c.renewable
+}
+
+// reloginDue reports whether the token is within ReloginBefore of its expiry.
+func (c *Client) reloginDue() bool {
+	if c.ReloginBefore <= 0 || !c.hasAppRole() {
+		return false
+	}
+	c.mu.RLock()
+	expiry := c.tokenExpiry
+	c.mu.RUnlock()
+	return !expiry.IsZero() && time.Until(expiry) <= c.ReloginBefore
+}
+
+// discoverToken returns the first token found in TokenFile, VAULT_TOKEN or
+// ~/.vault-token, or an empty string if none holds one. The environment
+// variable precedes the helper file for parity with the Vault CLI.
+func (c *Client) discoverToken() (string, error) {
+	if c.TokenFile != "" {
+		return readCredential("", c.TokenFile)
+	}
+	if token := strings.TrimSpace(os.Getenv("VAULT_TOKEN")); token != "" {
+		return token, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", nil
+	}
+	b, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read vault token helper file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (c *Client) hasAppRole() bool {
+	return (c.RoleID != "" || c.RoleIDFile != "") && (c.SecretID != "" || c.SecretIDFile != "")
+}
+
+// unwrapSecretID returns the SecretID wrapped by wrapToken, unwrapping it
+// only if wrapToken has not been seen before.
+func (c *Client) unwrapSecretID(ctx context.Context, wrapToken string) (string, error) {
+	c.mu.RLock()
+	cached, secret := c.wrapToken, c.unwrappedSecret
+	c.mu.RUnlock()
+	if cached == wrapToken && secret != "" {
+		return secret, nil
+	}
+
+	resp, err := c.doJSONWithToken(ctx, http.MethodPost, c.url("v1/sys/wrapping/unwrap"), nil, wrapToken, nil)
+	if err != nil {
+		var herr *HTTPError
+		if errors.As(err, &herr) && strings.Contains(herr.Body, wrapInvalidMessage) {
+			return "", fmt.Errorf("vault unwrap secret ID: %w: %w", ErrWrapExpired, err)
+		}
+		return "", fmt.Errorf("vault unwrap secret ID: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var out struct {
+		Data struct {
+			SecretID string `json:"secret_id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("vault unwrap secret ID: %w", err)
+	}
+	if out.Data.SecretID == "" {
+		return "", errors.New("vault unwrap secret ID: response has no secret_id")
+	}
+	c.mu.Lock()
+	c.wrapToken, c.unwrappedSecret = wrapToken, out.Data.SecretID
+	c.mu.Unlock()
+	return out.Data.SecretID, nil
+}
+
+// readCredential returns the trimmed contents of file if set, else inline.
+func readCredential(inline, file string) (string, error) {
+	if file == "" {
+		return inline, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return "", fmt.Errorf("vault credential file %s is empty", file)
+	}
+	return v, nil
 }
 
 func (c *Client) doJSON(ctx context.Context, method, url string, body any, requireAuth bool) (*http.Response, error) {
-	client := c.HTTPClient
-	if client == nil {
-		client = &http.Client{Timeout: 10 * time.Second}
+	var token string
+	if requireAuth {
+		if token = c.token(); token == "" {
+			return nil, ErrAuthRequired
+		}
 	}
+	return c.doJSONWithToken(ctx, method, url, body, token, nil)
+}
+
+// doJSONWithToken is doJSON with an explicit X-Vault-Token, e.g. a wrapping
+// token, and extra headers such as X-Vault-Wrap-TTL. An empty token sends
+// none.
+func (c *Client) doJSONWithToken(ctx context.Context, method, url string, body any, token string, header http.Header) (*http.Response, error) {
+	client := c.httpClient()
 
 	var buf io.Reader
 	if body != nil {
@@ -126,17 +568,17 @@ func (c *Client) doJSON(ctx context.Context, method, url string, body any, requi
 	if err != nil {
 		return nil, err
 	}
+	c.applyHeaders(req)
 	req.Header.Set("Content-Type", "application/json")
 	if c.Namespace != "" {
 		req.Header.Set("X-Vault-Namespace", c.Namespace)
 	}
-	if requireAuth {
-		token := c.token()
-		if token == "" {
-			return nil, ErrAuthRequired
-		}
+	if token != "" {
 		req.Header.Set("X-Vault-Token", token)
 	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -148,7 +590,49 @@ func (c *Client) doJSON(ctx context.Context, method, url string, body any, requi
 
 	defer func() { _ = resp.Body.Close() }()
 	msg, _ := io.ReadAll(resp.Body)
-	return nil, fmt.Errorf("vault http %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	return nil, newHTTPError(resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// applyHeaders sets the user-supplied headers and User-Agent on req.
+func (c *Client) applyHeaders(req *http.Request) {
+	for k, vs := range c.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+}
+
+// httpClient returns HTTPClient if set, otherwise a pooled client built once
+// and reused across calls so connections to Vault stay alive between rotations.
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	c.clientOnce.Do(func() {
+		timeout := c.Timeout
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		maxIdle := c.MaxIdleConnsPerHost
+		if maxIdle <= 0 {
+			maxIdle = 4
+		}
+		idleTimeout := c.IdleConnTimeout
+		if idleTimeout <= 0 {
+			idleTimeout = 90 * time.Second
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = maxIdle
+		transport.IdleConnTimeout = idleTimeout
+		c.client = &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		}
+	})
+	return c.client
 }
 
 func (c *Client) token() string {
@@ -160,16 +644,43 @@ func (c *Client) token() string {
 func (c *Client) setToken(token string) {
 	c.mu.Lock()
 	c.Token = token
+	if token == "" {
+		c.authMethod, c.tokenExpiry, c.renewable = "", time.Time{}, false
+	}
 	c.mu.Unlock()
 }
 
 func (c *Client) url(p string) string {
-	base := strings.TrimRight(c.Addr, "/")
-	p = strings.TrimLeft(p, "/")
-	return base + "/" + p
+	return joinURL(c.Addr, p)
 }
 
-func isAuthError(err error) bool {
-	s := err.Error()
-	return strings.Contains(s, "permission denied") || strings.Contains(s, "http 403") || strings.Contains(s, "http 401")
+// addrs returns Addr and Addrs without duplicates, last active node first.
+func (c *Client) addrs() []string {
+	c.mu.RLock()
+	active := c.activeAddr
+	c.mu.RUnlock()
+
+	out := make([]string, 0, 1+len(c.Addrs))
+	if active != "" {
+		out = append(out, active)
+	}
+	for _, addr := range append([]string{c.Addr}, c.Addrs...) {
+		if addr != "" && addr != active {
+			out = append(out, addr)
+		}
+	}
+	return dedupe(out)
+}
+
+func joinURL(base, p string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(p, "/")
+}
+
+func isStandbyMessage(msg string) bool {
+	for _, m := range standbyMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
 }
@@ -3,10 +3,15 @@ package vault
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestClientIssueRefreshesTokenOnAuthError(t *testing.T) {
@@ -55,6 +60,44 @@ func TestClientIssueRefreshesTokenOnAuthError(t *testing.T) {
 	}
 }
 
+func TestClientDisableAuthRetry(t *testing.T) {
+	t.Parallel()
+
+	var issueCalls, logins int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			logins++
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"auth": map[string]any{"client_token": "good"},
+			})
+		case "/v1/pki/issue/role":
+			issueCalls++
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{
+		Addr:             server.URL,
+		Token:            "revoked",
+		RoleID:           "role-id",
+		SecretID:         "secret-id",
+		DisableAuthRetry: true,
+	}
+	_, err := client.Issue(context.Background(), "pki", "role", IssueRequest{CommonName: "svc"})
+	var aerr *AuthError
+	if !errors.As(err, &aerr) {
+		t.Fatalf("Issue() = %v, want AuthError", err)
+	}
+	if issueCalls != 1 || logins != 0 {
+		t.Fatalf("issue calls = %d, logins = %d; want 1 and 0", issueCalls, logins)
+	}
+}
+
 func TestEnsureTokenClosesBody(t *testing.T) {
 	t.Parallel()
 
@@ -86,3 +129,688 @@ func TestEnsureTokenClosesBody(t *testing.T) {
 		t.Fatal("expected response body to be closed")
 	}
 }
+
+func TestClientReusesPooledTransport(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{
+		Addr:                server.URL,
+		Token:               "tok",
+		MaxIdleConnsPerHost: 8,
+	}
+
+	first := client.httpClient()
+	for i := 0; i < 3; i++ {
+		_, _ = client.Issue(context.Background(), "pki", "role", IssueRequest{})
+		if got := client.httpClient(); got != first || got.Transport != first.Transport {
+			t.Fatal("expected pooled http client and transport to be reused")
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+
+	transport, ok := first.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", first.Transport)
+	}
+	if transport.MaxIdleConnsPerHost != 8 {
+		t.Fatalf("MaxIdleConnsPerHost = %d, want 8", transport.MaxIdleConnsPerHost)
+	}
+}
+
+func TestClientHealth(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusOK, nil},
+		{http.StatusTooManyRequests, ErrStandby},
+		{473, ErrStandby},
+		{http.StatusNotImplemented, ErrUninitialized},
+		{http.StatusServiceUnavailable, ErrSealed},
+	}
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/sys/health" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(c.status)
+		}))
+		client := &Client{Addr: server.URL}
+		err := client.Health(context.Background())
+		server.Close()
+		if !errors.Is(err, c.want) {
+			t.Fatalf("status %d: Health() = %v, want %v", c.status, err, c.want)
+		}
+	}
+}
+
+func TestEnsureTokenReadsCredentialFiles(t *testing.T) {
+	t.Parallel()
+
+	var gotSecrets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "file-role" {
+			http.Error(w, "bad role_id", http.StatusBadRequest)
+			return
+		}
+		gotSecrets = append(gotSecrets, body["secret_id"])
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{"client_token": "tok"},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	roleFile := filepath.Join(dir, "role_id")
+	secretFile := filepath.Join(dir, "secret_id")
+	writeFile := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	writeFile(roleFile, "file-role\n")
+	writeFile(secretFile, "  secret-1\n")
+
+	client := &Client{
+		Addr:         server.URL,
+		RoleID:       "inline-role",
+		RoleIDFile:   roleFile,
+		SecretIDFile: secretFile,
+	}
+	if err := client.ensureToken(context.Background()); err != nil {
+		t.Fatalf("ensureToken failed: %v", err)
+	}
+
+	writeFile(secretFile, "secret-2")
+	client.setToken("")
+	if err := client.ensureToken(context.Background()); err != nil {
+		t.Fatalf("ensureToken failed: %v", err)
+	}
+	if len(gotSecrets) != 2 || gotSecrets[0] != "secret-1" || gotSecrets[1] != "secret-2" {
+		t.Fatalf("secret_ids = %v, want [secret-1 secret-2]", gotSecrets)
+	}
+
+	writeFile(secretFile, "\n")
+	client.setToken("")
+	if err := client.ensureToken(context.Background()); err == nil {
+		t.Fatal("expected empty credential file to return error")
+	}
+}
+
+func TestClientAuthInfo(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{
+				"client_token":   "tok",
+				"lease_duration": 3600,
+				"renewable":      true,
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	static := &Client{Token: "static"}
+	if method, expiry, renewable := static.AuthInfo(); method != "token" || !expiry.IsZero() || renewable {
+		t.Fatalf("AuthInfo() = %q, %s, %v; want token, zero, false", method, expiry, renewable)
+	}
+
+	client := &Client{Addr: server.URL, RoleID: "role-id", SecretID: "secret-id"}
+	if method, _, _ := client.AuthInfo(); method != "" {
+		t.Fatalf("expected empty method before login, got %q", method)
+	}
+	before := time.Now()
+	if err := client.ensureToken(context.Background()); err != nil {
+		t.Fatalf("ensureToken failed: %v", err)
+	}
+	method, expiry, renewable := client.AuthInfo()
+	if method != "approle" || !renewable {
+		t.Fatalf("AuthInfo() = %q, renewable=%v; want approle, true", method, renewable)
+	}
+	if expiry.Before(before.Add(time.Hour)) || expiry.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("token expiry %s not ~1h from login", expiry)
+	}
+}
+
+func TestClientAppliesHeaders(t *testing.T) {
+	t.Parallel()
+
+	var seen []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Clone())
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{"client_token": "good"},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{
+		Addr:      server.URL,
+		RoleID:    "role-id",
+		SecretID:  "secret-id",
+		UserAgent: "spiffe-rotate-test/1.0",
+		Headers: http.Header{
+			"X-Proxy-Auth":  {"allow"},
+			"User-Agent":    {"ignored"},
+			"X-Vault-Token": {"override"},
+			"Content-Type":  {"text/plain"},
+		},
+	}
+	_, _ = client.Issue(context.Background(), "pki", "role", IssueRequest{})
+
+	if len(seen) != 2 {
+		t.Fatalf("expected login and issue requests, got %d", len(seen))
+	}
+	for i, h := range seen {
+		if got := h.Get("X-Proxy-Auth"); got != "allow" {
+			t.Fatalf("request %d: X-Proxy-Auth = %q", i, got)
+		}
+		if got := h.Get("User-Agent"); got != "spiffe-rotate-test/1.0" {
+			t.Fatalf("request %d: User-Agent = %q", i, got)
+		}
+		if got := h.Get("Content-Type"); got != "application/json" {
+			t.Fatalf("request %d: Content-Type = %q", i, got)
+		}
+	}
+	if got := seen[1].Values("X-Vault-Token"); len(got) != 1 || got[0] != "good" {
+		t.Fatalf("issue X-Vault-Token = %v, want [good]", got)
+	}
+}
+
+func TestClientIssueFailsOverFromStandby(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var standbyCalls, activeCalls int
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		standbyCalls++
+		http.Error(w, `{"errors":["local node not active but active cluster node not found"]}`, http.StatusInternalServerError)
+	}))
+	t.Cleanup(standby.Close)
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		activeCalls++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(active.Close)
+
+	client := &Client{Addr: standby.URL, Addrs: []string{active.URL}, Token: "tok"}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Issue(context.Background(), "pki", "role", IssueRequest{}); err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+	}
+	if standbyCalls != 1 || activeCalls != 2 {
+		t.Fatalf("standby calls = %d, active calls = %d; want 1 and 2", standbyCalls, activeCalls)
+	}
+
+	alone := &Client{Addr: standby.URL, Token: "tok"}
+	if _, err := alone.Issue(context.Background(), "pki", "role", IssueRequest{}); !errors.Is(err, ErrStandby) {
+		t.Fatalf("Issue() = %v, want ErrStandby", err)
+	}
+}
+
+func TestClientListRoles(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list") != "true" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/pki/roles":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{"keys": []string{"mtls-client", "mtls-service"}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{Addr: server.URL, Token: "tok"}
+	roles, err := client.ListRoles(context.Background(), "pki")
+	if err != nil {
+		t.Fatalf("ListRoles failed: %v", err)
+	}
+	if len(roles) != 2 || roles[0] != "mtls-client" || roles[1] != "mtls-service" {
+		t.Fatalf("roles = %v", roles)
+	}
+
+	roles, err = client.ListRoles(context.Background(), "pki-empty")
+	if err != nil {
+		t.Fatalf("ListRoles on empty mount failed: %v", err)
+	}
+	if len(roles) != 0 {
+		t.Fatalf("expected no roles, got %v", roles)
+	}
+}
+
+// TestClientTokenSources is not parallel: it sets HOME and VAULT_TOKEN.
+func TestClientTokenSources(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("VAULT_TOKEN", "")
+	ctx := context.Background()
+
+	if err := (&Client{}).ensureToken(ctx); !errors.Is(err, ErrAuthRequired) {
+		t.Fatalf("ensureToken() = %v, want ErrAuthRequired without any token source", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, ".vault-token"), []byte("helper-tok\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{}
+	if err := client.ensureToken(ctx); err != nil || client.token() != "helper-tok" {
+		t.Fatalf("ensureToken() = %v, token %q; want the token helper file", err, client.token())
+	}
+	if method, _, _ := client.AuthInfo(); method != "token" {
+		t.Fatalf("AuthInfo method = %q, want token", method)
+	}
+
+	t.Setenv("VAULT_TOKEN", "env-tok")
+	client = &Client{}
+	if err := client.ensureToken(ctx); err != nil || client.token() != "env-tok" {
+		t.Fatalf("ensureToken() = %v, token %q; want VAULT_TOKEN over the helper file", err, client.token())
+	}
+
+	sink := filepath.Join(t.TempDir(), "sink")
+	if err := os.WriteFile(sink, []byte("sink-tok-1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client = &Client{TokenFile: sink}
+	if err := client.ensureToken(ctx); err != nil || client.token() != "sink-tok-1" {
+		t.Fatalf("ensureToken() = %v, token %q; want TokenFile over VAULT_TOKEN", err, client.token())
+	}
+	if err := os.WriteFile(sink, []byte("sink-tok-2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client.InvalidateToken()
+	if err := client.ensureToken(ctx); err != nil || client.token() != "sink-tok-2" {
+		t.Fatalf("ensureToken() = %v, token %q; want the rotated sink token", err, client.token())
+	}
+
+	client = &Client{Token: "explicit", TokenFile: sink}
+	if err := client.ensureToken(ctx); err != nil || client.token() != "explicit" {
+		t.Fatalf("ensureToken() = %v, token %q; want Token to win", err, client.token())
+	}
+}
+
+func TestClientInvalidateTokenAndRelogin(t *testing.T) {
+	t.Parallel()
+
+	var logins int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{"client_token": "tok", "lease_duration": 60},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{Addr: server.URL, RoleID: "role-id", SecretID: "secret-id"}
+	for i := 0; i < 2; i++ {
+		if err := client.ensureToken(context.Background()); err != nil {
+			t.Fatalf("ensureToken failed: %v", err)
+		}
+	}
+	if logins != 1 {
+		t.Fatalf("expected cached token to be reused, got %d logins", logins)
+	}
+
+	client.InvalidateToken()
+	if err := client.ensureToken(context.Background()); err != nil {
+		t.Fatalf("ensureToken failed: %v", err)
+	}
+	if logins != 2 {
+		t.Fatalf("expected re-login after InvalidateToken, got %d logins", logins)
+	}
+
+	// The 60s lease is within ReloginBefore, so every call re-authenticates.
+	client.ReloginBefore = 2 * time.Minute
+	if err := client.ensureToken(context.Background()); err != nil {
+		t.Fatalf("ensureToken failed: %v", err)
+	}
+	if logins != 3 {
+		t.Fatalf("expected proactive re-login near token expiry, got %d logins", logins)
+	}
+}
+
+func TestClientIssueRaw(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"warnings": []string{"ttl capped"},
+			"data": map[string]any{
+				"certificate":   string(leafPEM),
+				"private_key":   string(keyPEM),
+				"serial_number": "2a:0f",
+				"expiration":    1767225600,
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{Addr: server.URL, Token: "tok"}
+	resp, raw, err := client.IssueRaw(context.Background(), "pki", "role", IssueRequest{})
+	if err != nil {
+		t.Fatalf("IssueRaw failed: %v", err)
+	}
+	if resp.Certificate != string(leafPEM) {
+		t.Fatal("expected typed response alongside raw body")
+	}
+	data, _ := raw["data"].(map[string]any)
+	if data["serial_number"] != "2a:0f" || data["expiration"] != float64(1767225600) {
+		t.Fatalf("raw data = %v, want serial_number and expiration", data)
+	}
+	if warnings, _ := raw["warnings"].([]any); len(warnings) != 1 {
+		t.Fatalf("raw warnings = %v, want 1 entry", raw["warnings"])
+	}
+}
+
+func TestClientOnToken(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{"client_token": "tok", "lease_duration": 60},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	type tokenEvent struct {
+		event string
+		ttl   time.Duration
+	}
+	events := make(chan tokenEvent, 4)
+	client := &Client{
+		Addr:     server.URL,
+		RoleID:   "role-id",
+		SecretID: "secret-id",
+		OnToken:  func(event string, ttl time.Duration) { events <- tokenEvent{event, ttl} },
+	}
+	next := func() tokenEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for OnToken")
+			return tokenEvent{}
+		}
+	}
+
+	if err := client.ensureToken(context.Background()); err != nil {
+		t.Fatalf("ensureToken failed: %v", err)
+	}
+	if e := next(); e.event != TokenLogin || e.ttl != time.Minute {
+		t.Fatalf("event = %+v, want login with 1m TTL", e)
+	}
+
+	client.ReloginBefore = 2 * time.Minute
+	if err := client.ensureToken(context.Background()); err != nil {
+		t.Fatalf("ensureToken failed: %v", err)
+	}
+	if e := next(); e.event != TokenRelogin {
+		t.Fatalf("event = %+v, want relogin", e)
+	}
+
+	client.InvalidateToken()
+	if e := next(); e.event != TokenInvalidated || e.ttl != 0 {
+		t.Fatalf("event = %+v, want invalidate with zero TTL", e)
+	}
+}
+
+func TestClientTypedErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/pki/issue/denied":
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		case "/v1/pki/issue/broken":
+			http.Error(w, `{"errors":["internal error"]}`, http.StatusInternalServerError)
+		case "/v1/pki/issue/html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>proxy login</html>"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := &Client{Addr: server.URL, Token: "tok"}
+
+	_, err := client.Issue(context.Background(), "pki", "denied", IssueRequest{})
+	var aerr *AuthError
+	var herr *HTTPError
+	if !errors.As(err, &aerr) || !errors.As(err, &herr) || herr.StatusCode != http.StatusForbidden {
+		t.Fatalf("Issue() = %v, want AuthError wrapping HTTP 403", err)
+	}
+
+	_, err = client.Issue(context.Background(), "pki", "broken", IssueRequest{})
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusInternalServerError || !strings.Contains(herr.Body, "internal error") {
+		t.Fatalf("Issue() = %v, want HTTPError 500 with body", err)
+	}
+	if errors.As(err, &aerr) {
+		t.Fatal("expected a 500 not to be an AuthError")
+	}
+
+	_, err = client.Issue(context.Background(), "pki", "html", IssueRequest{})
+	var derr *DecodeError
+	if !errors.As(err, &derr) || derr.ContentType != "text/html" || !strings.Contains(derr.Body, "proxy login") {
+		t.Fatalf("Issue() = %v, want DecodeError with the body", err)
+	}
+}
+
+func TestClientUnwrapsSecretID(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var unwraps int
+	used := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/unwrap":
+			unwraps++
+			token := r.Header.Get("X-Vault-Token")
+			if token != "wrap-1" || used[token] {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors":["wrapping token is not valid or does not exist"]}`))
+				return
+			}
+			used[token] = true
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"secret_id": "real-secret"}})
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["secret_id"] != "real-secret" {
+				http.Error(w, `{"errors":["invalid secret id"]}`, http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": "tok"}})
+		case "/v1/pki/issue/role":
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{Addr: server.URL, RoleID: "role", SecretID: "wrap-1", SecretIDWrapped: true}
+	for range 2 {
+		if _, err := client.Issue(context.Background(), "pki", "role", IssueRequest{}); err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		client.InvalidateToken()
+	}
+	if unwraps != 1 {
+		t.Fatalf("unwrapped %d times, want the SecretID reused for relogin", unwraps)
+	}
+
+	client.SecretID = "wrap-2"
+	_, err := client.Issue(context.Background(), "pki", "role", IssueRequest{})
+	if !errors.Is(err, ErrWrapExpired) {
+		t.Fatalf("Issue() = %v, want ErrWrapExpired", err)
+	}
+}
+
+func TestClientUnwrapsIssueResponse(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var mu sync.Mutex
+	used := map[string]bool{}
+	wrap := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/pki/issue/role":
+			if r.Header.Get("X-Vault-Token") != "tok" {
+				http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+				return
+			}
+			if got := r.Header.Get("X-Vault-Wrap-TTL"); got != "60s" {
+				t.Errorf("X-Vault-Wrap-TTL = %q, want 60s", got)
+			}
+			if !wrap {
+				_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+					"certificate": string(leafPEM),
+					"private_key": string(keyPEM),
+				}})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"wrap_info": map[string]any{"token": "wrapped-issue", "ttl": 60}})
+		case "/v1/sys/wrapping/unwrap":
+			token := r.Header.Get("X-Vault-Token")
+			if token != "wrapped-issue" || used[token] {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors":["wrapping token is not valid or does not exist"]}`))
+				return
+			}
+			used[token] = true
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"certificate":      string(leafPEM),
+				"private_key":      string(keyPEM),
+				"private_key_type": "rsa",
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := &Client{Addr: server.URL, Token: "tok"}
+	req := IssueRequest{WrapTTL: time.Minute}
+	resp, err := client.Issue(context.Background(), "pki", "role", req)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if resp.Certificate != string(leafPEM) || resp.PrivateKeyType != "rsa" {
+		t.Fatalf("Issue() = %+v, want the unwrapped response", resp)
+	}
+
+	// The wrapping token is single-use.
+	_, err = client.Issue(context.Background(), "pki", "role", req)
+	if !errors.Is(err, ErrWrapExpired) {
+		t.Fatalf("Issue() = %v, want ErrWrapExpired", err)
+	}
+
+	mu.Lock()
+	wrap = false
+	mu.Unlock()
+	_, err = client.Issue(context.Background(), "pki", "role", req)
+	if err == nil || !strings.Contains(err.Error(), "not wrapped") {
+		t.Fatalf("Issue() = %v, want an error for an unwrapped response", err)
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var mu sync.Mutex
+	failLogin := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			if failLogin {
+				http.Error(w, `{"errors":["invalid role or secret ID"]}`, http.StatusBadRequest)
+				return
+			}
+			// Short enough that every request falls within ReloginBefore.
+			_ = json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": "tok", "lease_duration": 60}})
+		case "/v1/pki/issue/role":
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	metrics := &PrometheusMetrics{}
+	client := &Client{Addr: server.URL, RoleID: "role", SecretID: "secret", ReloginBefore: time.Hour, Metrics: metrics}
+	if _, err := client.Issue(context.Background(), "pki", "role", IssueRequest{}); err == nil {
+		t.Fatal("expected Issue to fail while login is rejected")
+	}
+	mu.Lock()
+	failLogin = false
+	mu.Unlock()
+	for range 2 {
+		if _, err := client.Issue(context.Background(), "pki", "role", IssueRequest{}); err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		"# TYPE vault_logins_total counter\nvault_logins_total 2\n",
+		"vault_login_failures_total 1\n",
+		"vault_token_refreshes_total 1\n",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// newCSR returns a PEM-encoded CSR for req signed by signer. Vault applies
+// the role's policy to the request fields, so the CSR mirrors them.
+func newCSR(signer crypto.Signer, req IssueRequest) (string, error) {
+	tmpl := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: req.CommonName, SerialNumber: req.SerialNumber},
+	}
+	tmpl.DNSNames = req.AltNames
+	for _, raw := range req.IPSANs {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return "", fmt.Errorf("invalid ip SAN %q", raw)
+		}
+		tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+	}
+	for _, raw := range req.URISANs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid uri SAN %q: %w", raw, err)
+		}
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, signer)
+	if err != nil {
+		return "", fmt.Errorf("create CSR: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// signerKeyPair assembles a tls.Certificate from the signed cert and the
+// signer that holds its private key.
+func signerKeyPair(certPEM []byte, signer crypto.Signer) (tls.Certificate, error) {
+	leaf, err := parseLeafPEM(certPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := checkKeyMatch(leaf, signer); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  signer,
+		Leaf:        leaf,
+	}, nil
+}
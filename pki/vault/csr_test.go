@@ -0,0 +1,121 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIssuerSignsCSRWithSigner(t *testing.T) {
+	t.Parallel()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+
+	var gotCSR *x509.CertificateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/pki/sign/role" {
+			http.NotFound(w, r)
+			return
+		}
+		var body SignRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		block, _ := pem.Decode([]byte(body.CSR))
+		if block == nil {
+			http.Error(w, "bad csr", http.StatusBadRequest)
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			http.Error(w, "bad csr", http.StatusBadRequest)
+			return
+		}
+		gotCSR = csr
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      csr.Subject,
+			URIs:         csr.URIs,
+			NotBefore:    now.Add(-time.Minute),
+			NotAfter:     now.Add(30 * time.Minute),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caTmpl, csr.PublicKey, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+				"issuing_ca":  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signer: %v", err)
+	}
+	issuer := &Issuer{
+		Client:     &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:    "pki",
+		Role:       "role",
+		CommonName: "svc",
+		URISANs:    []string{"spiffe://corp/prod/svc"},
+		Signer:     signer,
+	}
+	bundle, err := issuer.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if gotCSR == nil || gotCSR.Subject.CommonName != "svc" || len(gotCSR.URIs) != 1 {
+		t.Fatalf("unexpected CSR: %+v", gotCSR)
+	}
+	if bundle.Cert.PrivateKey != signer {
+		t.Fatal("expected bundle private key to be the signer")
+	}
+	if !bundle.Cert.Leaf.PublicKey.(*ecdsa.PublicKey).Equal(&signer.PublicKey) {
+		t.Fatal("expected leaf public key to match signer")
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if _, err := signerKeyPair([]byte(issuerCertPEM(t, bundle.Cert.Certificate[0])), other); err == nil {
+		t.Fatal("expected mismatched signer to be rejected")
+	}
+}
+
+func issuerCertPEM(t *testing.T, der []byte) string {
+	t.Helper()
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
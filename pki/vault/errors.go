@@ -0,0 +1,74 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HTTPError is a non-2xx response from Vault. Body is the trimmed response
+// body, usually a JSON {"errors": [...]} document.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+
+	err error // e.g. ErrStandby, for errors.Is
+}
+
+func (e *HTTPError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("vault http %d: %s: %v", e.StatusCode, e.Body, e.err)
+	}
+	return fmt.Sprintf("vault http %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *HTTPError) Unwrap() error { return e.err }
+
+// AuthError reports that Vault rejected the request's token (HTTP 401 or
+// 403). It wraps the HTTPError, so both are reachable with errors.As.
+type AuthError struct {
+	Err *HTTPError
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// DecodeError reports a 2xx response whose body could not be decoded, e.g.
+// an HTML page from a proxy in front of Vault.
+type DecodeError struct {
+	StatusCode  int
+	ContentType string
+	Body        string // truncated
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("vault issue response (http %d, %s): decode: %v; body: %q",
+		e.StatusCode, e.ContentType, e.Err, e.Body)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// newHTTPError returns the error for a non-2xx response, as an AuthError for
+// 401 and 403.
+func newHTTPError(status int, body string) error {
+	herr := &HTTPError{StatusCode: status, Body: body}
+	if isStandbyMessage(body) {
+		herr.err = ErrStandby
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return &AuthError{Err: herr}
+	}
+	return herr
+}
+
+func isNotFound(err error) bool {
+	var herr *HTTPError
+	return errors.As(err, &herr) && herr.StatusCode == http.StatusNotFound
+}
+
+func isAuthError(err error) bool {
+	var aerr *AuthError
+	return errors.As(err, &aerr)
+}
@@ -1,26 +1,155 @@
 package vault
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strconv"
 	"time"
 
 	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+	"github.com/cmmoran/spiffe-rotate/pki/spiffe"
 )
 
 type Issuer struct {
 	Client  *Client
 	PKIPath string
 	Role    string
+	// IssuerRef pins issuance to a specific issuer on a multi-issuer mount
+	// via <PKIPath>/issuer/<IssuerRef>/issue/<Role>. Empty uses the role default.
+	IssuerRef string
 
 	CommonName string
 	AltNames   []string
+	IPSANs     []string
 	URISANs    []string
-	TTL        time.Duration
-	// RequireCA enforces that the issuer returns a CA chain or issuing CA.
+	// TTL is the requested lifetime. A shorter certmanager.RecoveryTTL from
+	// the context overrides it and NotAfter.
+	TTL time.Duration
+	// NotAfter requests an absolute expiry and takes precedence over TTL.
+	NotAfter time.Time
+	// MaxTTL, if set, is a client-side ceiling on the requested lifetime,
+	// applied after RequestHook. Longer TTL or NotAfter requests are clamped
+	// to it with a warning, or rejected with StrictMaxTTL. Requests that leave
+	// the lifetime to the role are not checked.
+	MaxTTL time.Duration
+	// StrictMaxTTL rejects requests exceeding MaxTTL instead of clamping them.
+	StrictMaxTTL bool
+	// NotBeforeDuration backdates issued certs' NotBefore by this much, so a
+	// peer whose clock lags the issuer's does not reject a just-issued cert
+	// as not yet valid. Zero leaves it to the role's not_before_duration.
+	// Rotation is scheduled from NotAfter, so backdating does not shorten
+	// the time until the next rotation.
+	NotBeforeDuration time.Duration
+	// SubjectSerialNumber sets the subject serialNumber attribute, e.g. for
+	// device identities. It is unrelated to the certificate's serial number.
+	SubjectSerialNumber string
+	// SignatureBits requests the hash Vault signs the cert with (256, 384 or
+	// 512), e.g. for partners that mandate SHA-384. Vault applies it per
+	// request only where the role and version allow; otherwise the role's
+	// signature_bits wins, so check the issued cert if it matters.
+	SignatureBits int
+	// NoStore asks Vault not to store issued certs, keeping storage and
+	// issuance latency flat for high-volume short-lived certs. Such certs
+	// cannot be revoked, so rely on short TTLs instead. Vault honors the
+	// request parameter only where the version supports it; the role's
+	// no_store setting applies otherwise.
+	NoStore bool
+	// WrapTTL, if positive, has Vault response-wrap issue and sign responses
+	// for this long. The client unwraps them via sys/wrapping/unwrap, so the
+	// cert and key cross intermediaries (e.g. TLS-terminating proxies) only
+	// inside a single-use wrapping token. A response that comes back
+	// unwrapped, or whose token was already used (ErrWrapExpired), fails
+	// issuance.
+	WrapTTL time.Duration
+	// RequireCA enforces that the issuer returns a CA chain or issuing CA
+	// holding at least one valid certificate, after LenientCA filtering.
 	RequireCA bool
+	// LenientCA skips ca_chain or issuing_ca entries holding invalid PEM,
+	// logging them to Logger, instead of failing issuance. The bundle's CA
+	// pool may then be partial or empty, unless RequireCA is set, so use it
+	// only where peers are verified against independently configured trust
+	// anchors.
+	LenientCA bool
+	// IncludeRoot presents self-signed roots from the CA chain after the
+	// intermediates. By default they are omitted, as peers must already
+	// trust the root for the chain to verify.
+	IncludeRoot bool
+	// EnforceURISANs rejects issued certs that do not carry every requested
+	// URI SAN, catching roles that silently drop uri_sans.
+	EnforceURISANs bool
+	// RequiredSANs lists the critical DNS, IP or URI SANs the issued cert
+	// must carry, failing issuance when a role silently filters any of them.
+	// Unlike EnforceURISANs, non-critical requested SANs may be dropped.
+	RequiredSANs []string
+	// Logger receives warnings, e.g. SANs the role added that were not
+	// requested. Defaults to discarding output.
+	Logger *slog.Logger
+	// ExpectedCAFingerprints pins the CAs the issued leaf must chain up to,
+	// by SHA-256 of the CA cert's DER encoding. Empty disables pinning.
+	ExpectedCAFingerprints [][sha256.Size]byte
+	// RequestHook, if set, may modify the request just before it is sent,
+	// e.g. to shorten the TTL under load. It runs on every Issue call,
+	// including rotations retried after an error. An error aborts issuance.
+	RequestHook func(context.Context, *IssueRequest) error
+	// LegacyStringSANs sends SAN lists as comma-separated strings for older
+	// Vault versions that reject JSON arrays. See IssueRequest.
+	LegacyStringSANs bool
+	// Signer, if set, keeps the private key out of Vault: a CSR signed by it
+	// is sent to pki/sign and the bundle's PrivateKey is the Signer itself,
+	// e.g. an HSM-backed key.
+	Signer crypto.Signer
+}
+
+// PlannedRequest returns the request Issue would send for ctx, without
+// contacting Vault: SANs deduplicated, TTL or NotAfter chosen (including a
+// RecoveryTTL in ctx), RequestHook applied, MaxTTL enforced and URI SANs
+// validated. It is meant for dry runs, e.g. a config validator showing the
+// SANs a role will be asked for. RequestHook runs as it would for Issue.
+func (i *Issuer) PlannedRequest(ctx context.Context) (IssueRequest, error) {
+	req := IssueRequest{
+		CommonName: i.CommonName,
+		AltNames:   dedupe(i.AltNames),
+		IPSANs:     dedupe(i.IPSANs),
+		URISANs:    dedupe(i.URISANs),
+
+		SerialNumber:     i.SubjectSerialNumber,
+		SignatureBits:    i.SignatureBits,
+		NoStore:          i.NoStore,
+		LegacyStringSANs: i.LegacyStringSANs,
+		WrapTTL:          i.WrapTTL,
+	}
+	if !i.NotAfter.IsZero() {
+		req.NotAfter = i.NotAfter.UTC().Format(time.RFC3339)
+	} else if i.TTL > 0 {
+		req.TTL = i.TTL.String()
+	}
+	if i.NotBeforeDuration > 0 {
+		req.NotBeforeDuration = i.NotBeforeDuration.String()
+	}
+	if ttl := certmanager.RecoveryTTL(ctx); ttl > 0 && i.shortens(ttl) {
+		req.TTL, req.NotAfter = ttl.String(), ""
+	}
+	if i.RequestHook != nil {
+		if err := i.RequestHook(ctx, &req); err != nil {
+			return IssueRequest{}, fmt.Errorf("vault issue request hook: %w", err)
+		}
+	}
+	if err := i.capTTL(ctx, &req); err != nil {
+		return IssueRequest{}, err
+	}
+	if _, err := validateURISANs(req.URISANs); err != nil {
+		return IssueRequest{}, err
+	}
+	return req, nil
 }
 
 func (i *Issuer) Issue(ctx context.Context) (*certmanager.Bundle, error) {
@@ -28,48 +157,332 @@ func (i *Issuer) Issue(ctx context.Context) (*certmanager.Bundle, error) {
 		return nil, errors.New("vault client required")
 	}
 
-	req := IssueRequest{
-		CommonName: i.CommonName,
-		AltNames:   i.AltNames,
-		URISANs:    i.URISANs,
+	req, err := i.PlannedRequest(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if i.TTL > 0 {
-		req.TTL = i.TTL.String()
+
+	pkiPath := i.PKIPath
+	if pkiPath != "" && i.IssuerRef != "" {
+		pkiPath = path.Join(pkiPath, "issuer", i.IssuerRef)
 	}
 
-	resp, err := i.Client.Issue(ctx, i.PKIPath, i.Role, req)
+	var resp *IssueResponse
+	if i.Signer != nil {
+		var csr string
+		if csr, err = newCSR(i.Signer, req); err != nil {
+			return nil, err
+		}
+		resp, err = i.Client.Sign(ctx, pkiPath, i.Role, SignRequest{IssueRequest: req, CSR: csr})
+	} else {
+		resp, err = i.Client.Issue(ctx, pkiPath, i.Role, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Parse the leaf first so a mislabeled certificate field is reported as
+	// such rather than as a key pair mismatch.
+	notAfter, err := parseNotAfter([]byte(resp.Certificate))
 	if err != nil {
 		return nil, err
 	}
 
-	cert, err := tls.X509KeyPair([]byte(resp.Certificate), []byte(resp.PrivateKey))
+	var cert tls.Certificate
+	if i.Signer != nil {
+		cert, err = signerKeyPair([]byte(resp.Certificate), i.Signer)
+	} else {
+		cert, err = parseKeyPair([]byte(resp.Certificate), []byte(resp.PrivateKey), resp.PrivateKeyType)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := checkKeyMatch(cert.Leaf, cert.PrivateKey); err != nil {
+		return nil, err
+	}
+	if i.EnforceURISANs {
+		if err := checkURISANs(cert.Leaf, req.URISANs); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkRequiredSANs(cert.Leaf, i.RequiredSANs); err != nil {
+		return nil, err
+	}
+	if extra := extraSANs(cert.Leaf, req); len(extra) > 0 && i.Logger != nil {
+		i.Logger.WarnContext(ctx, "vault: issued cert carries SANs that were not requested",
+			"role", i.Role, "sans", extra)
+	}
 
+	// Each ca_chain element, like issuing_ca, may hold several concatenated
+	// PEM certs; parseCertsPEM returns all of them.
 	pool := x509.NewCertPool()
+	var caCerts []*x509.Certificate
+	addCA := func(field, pem string) error {
+		certs := parseCertsPEM([]byte(pem))
+		if len(certs) > 0 {
+			caCerts = append(caCerts, certs...)
+			return nil
+		}
+		if !i.LenientCA {
+			return fmt.Errorf("vault %s contained invalid PEM", field)
+		}
+		if i.Logger != nil {
+			i.Logger.WarnContext(ctx, "vault: skipping invalid CA PEM",
+				"role", i.Role, "field", field, "pem", snippet([]byte(pem)))
+		}
+		return nil
+	}
 	for _, pem := range resp.CAChain {
-		if !pool.AppendCertsFromPEM([]byte(pem)) {
-			return nil, errors.New("vault ca_chain contained invalid PEM")
+		if err := addCA("ca_chain", pem); err != nil {
+			return nil, err
 		}
 	}
 	if len(resp.CAChain) == 0 && resp.IssuingCA != "" {
-		if !pool.AppendCertsFromPEM([]byte(resp.IssuingCA)) {
-			return nil, errors.New("vault issuing_ca contained invalid PEM")
+		if err := addCA("issuing_ca", resp.IssuingCA); err != nil {
+			return nil, err
 		}
 	}
-	if i.RequireCA && len(resp.CAChain) == 0 && resp.IssuingCA == "" {
-		return nil, errors.New("vault issue response missing ca_chain/issuing_ca")
+	for _, cert := range caCerts {
+		pool.AddCert(cert)
 	}
-
-	notAfter, err := parseNotAfter([]byte(resp.Certificate))
-	if err != nil {
-		return nil, err
+	appendIntermediates(&cert, caCerts, i.IncludeRoot)
+	if len(i.ExpectedCAFingerprints) > 0 {
+		if err := checkPinnedCA(cert.Leaf, caCerts, i.ExpectedCAFingerprints); err != nil {
+			return nil, err
+		}
+	}
+	if i.RequireCA && len(caCerts) == 0 {
+		return nil, errors.New("vault issue response missing ca_chain/issuing_ca")
 	}
 
 	return &certmanager.Bundle{
 		Cert:     &cert,
 		CA:       pool,
+		CACerts:  caCerts,
 		NotAfter: notAfter,
 	}, nil
 }
+
+// shortens reports whether ttl expires sooner than the configured lifetime.
+// An unset lifetime defers to the role, so any recovery TTL applies.
+func (i *Issuer) shortens(ttl time.Duration) bool {
+	if !i.NotAfter.IsZero() {
+		return time.Now().Add(ttl).Before(i.NotAfter)
+	}
+	return i.TTL <= 0 || ttl < i.TTL
+}
+
+// capTTL enforces MaxTTL on the lifetime req asks for.
+func (i *Issuer) capTTL(ctx context.Context, req *IssueRequest) error {
+	if i.MaxTTL <= 0 {
+		return nil
+	}
+	var requested time.Duration
+	switch {
+	case req.NotAfter != "":
+		notAfter, err := time.Parse(time.RFC3339, req.NotAfter)
+		if err != nil {
+			return fmt.Errorf("vault issue request not_after %q: %w", req.NotAfter, err)
+		}
+		requested = time.Until(notAfter)
+	case req.TTL != "":
+		ttl, err := parseTTL(req.TTL)
+		if err != nil {
+			return err
+		}
+		requested = ttl
+	default:
+		return nil
+	}
+	if requested <= i.MaxTTL {
+		return nil
+	}
+	if i.StrictMaxTTL {
+		return fmt.Errorf("vault issue request lifetime %s exceeds MaxTTL %s", requested.Round(time.Second), i.MaxTTL)
+	}
+	if i.Logger != nil {
+		i.Logger.WarnContext(ctx, "vault: clamping requested lifetime to MaxTTL",
+			"role", i.Role, "requested", requested.Round(time.Second), "max_ttl", i.MaxTTL)
+	}
+	req.TTL, req.NotAfter = i.MaxTTL.String(), ""
+	return nil
+}
+
+// parseTTL parses a TTL as a Go duration or, as Vault also accepts, a bare
+// number of seconds.
+func parseTTL(ttl string) (time.Duration, error) {
+	if d, err := time.ParseDuration(ttl); err == nil {
+		return d, nil
+	}
+	secs, err := strconv.ParseInt(ttl, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("vault issue request ttl %q is not a duration", ttl)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// dedupe returns values without duplicates, preserving first-seen order.
+func dedupe(values []string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	seen := make(map[string]struct{}, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
+
+func validateURISANs(sans []string) ([]string, error) {
+	for _, san := range sans {
+		u, err := url.Parse(san)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uri SAN %q: %w", san, err)
+		}
+		if u.Scheme == "" {
+			return nil, fmt.Errorf("invalid uri SAN %q: missing scheme", san)
+		}
+		if u.Scheme == "spiffe" {
+			if _, err := spiffe.Parse(san); err != nil {
+				return nil, fmt.Errorf("invalid uri SAN: %w", err)
+			}
+		}
+	}
+	return sans, nil
+}
+
+// checkURISANs reports an error if leaf lacks any of the requested URI SANs.
+func checkURISANs(leaf *x509.Certificate, requested []string) error {
+	if len(requested) == 0 {
+		return nil
+	}
+	if leaf == nil {
+		return errors.New("vault issued cert could not be parsed to check uri SANs")
+	}
+	issued := make(map[string]struct{}, len(leaf.URIs))
+	for _, u := range leaf.URIs {
+		issued[u.String()] = struct{}{}
+	}
+	var missing []string
+	for _, san := range requested {
+		if _, ok := issued[san]; !ok {
+			missing = append(missing, san)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("vault issued cert missing requested uri SANs %v; check the role's allowed_uri_sans", missing)
+	}
+	return nil
+}
+
+// leafSANs returns every DNS, IP and URI SAN in leaf as strings.
+func leafSANs(leaf *x509.Certificate) []string {
+	sans := append([]string(nil), leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range leaf.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// checkRequiredSANs reports an error if leaf lacks any of the required SANs.
+func checkRequiredSANs(leaf *x509.Certificate, required []string) error {
+	if len(required) == 0 {
+		return nil
+	}
+	if leaf == nil {
+		return errors.New("vault issued cert could not be parsed to check required SANs")
+	}
+	issued := make(map[string]struct{})
+	for _, san := range leafSANs(leaf) {
+		issued[san] = struct{}{}
+	}
+	var missing []string
+	for _, san := range required {
+		if _, ok := issued[san]; !ok {
+			missing = append(missing, san)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("vault issued cert missing required SANs %v; check the role's allowed domains and URI SANs", missing)
+	}
+	return nil
+}
+
+// extraSANs returns the SANs in leaf that req did not ask for. The common
+// name counts as requested, since Vault adds it to the DNS SANs by default.
+func extraSANs(leaf *x509.Certificate, req IssueRequest) []string {
+	if leaf == nil {
+		return nil
+	}
+	requested := map[string]struct{}{req.CommonName: {}}
+	for _, list := range [][]string{req.AltNames, req.IPSANs, req.URISANs} {
+		for _, san := range list {
+			requested[san] = struct{}{}
+		}
+	}
+	var extra []string
+	for _, san := range leafSANs(leaf) {
+		if _, ok := requested[san]; !ok {
+			extra = append(extra, san)
+		}
+	}
+	return extra
+}
+
+// checkPinnedCA verifies leaf against caCerts and requires some CA in a
+// resulting chain to match one of the pinned fingerprints. Only the top of
+// the returned chain (self-signed roots, or CAs no other returned CA issued)
+// is used as a root, so chains run through every intermediate and a pinned
+// root is reachable.
+func checkPinnedCA(leaf *x509.Certificate, caCerts []*x509.Certificate, pins [][sha256.Size]byte) error {
+	if leaf == nil {
+		return errors.New("vault issued cert could not be parsed to check CA pins")
+	}
+	if len(caCerts) == 0 {
+		return errors.New("vault issue response missing ca_chain/issuing_ca required for CA pinning")
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for _, ca := range caCerts {
+		if selfSigned(ca) || !issuedByAny(ca, caCerts) {
+			roots.AddCert(ca)
+		} else {
+			intermediates.AddCert(ca)
+		}
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("vault issued cert does not chain to returned CA: %w", err)
+	}
+	for _, chain := range chains {
+		for _, ca := range chain[1:] {
+			sum := sha256.Sum256(ca.Raw)
+			for _, pin := range pins {
+				if sum == pin {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("vault issued cert does not chain to a pinned CA")
+}
+
+// issuedByAny reports whether another cert in cas issued cert.
+func issuedByAny(cert *x509.Certificate, cas []*x509.Certificate) bool {
+	for _, ca := range cas {
+		if ca != cert && bytes.Equal(cert.RawIssuer, ca.RawSubject) && cert.CheckSignatureFrom(ca) == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -1,18 +1,32 @@
 package vault
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"log/slog"
 	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager/certmanagertest"
 )
 
 func TestIssuerRejectsInvalidCAPEM(t *testing.T) {
@@ -48,6 +62,82 @@ func TestIssuerRejectsInvalidCAPEM(t *testing.T) {
 	_ = caPEM
 }
 
+func TestIssuerLenientCA(t *testing.T) {
+	t.Parallel()
+
+	caPEM, leafPEM, keyPEM := newTestCerts(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+				"ca_chain":    []string{"not pem", string(caPEM)},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	var logs bytes.Buffer
+	issuer := &Issuer{
+		Client:  &Client{Addr: server.URL, Token: "tok"},
+		PKIPath: "pki",
+		Role:    "role",
+		Logger:  slog.New(slog.NewTextHandler(&logs, nil)),
+	}
+	if _, err := issuer.Issue(context.Background()); err == nil {
+		t.Fatal("expected invalid ca_chain PEM to fail by default")
+	}
+
+	issuer.LenientCA = true
+	bundle, err := issuer.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed in lenient mode: %v", err)
+	}
+	if len(bundle.CACerts) != 1 {
+		t.Fatalf("got %d CA certs, want the valid one kept", len(bundle.CACerts))
+	}
+	if !strings.Contains(logs.String(), "not pem") {
+		t.Fatalf("expected the bad CA PEM to be logged, got %q", logs.String())
+	}
+}
+
+func TestIssuerRequireCAAfterLenientFiltering(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+				"ca_chain":    []string{"not pem", "also not pem"},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:    &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:   "pki",
+		Role:      "role",
+		LenientCA: true,
+	}
+	bundle, err := issuer.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed in lenient mode: %v", err)
+	}
+	if len(bundle.CACerts) != 0 {
+		t.Fatalf("got %d CA certs, want every garbage entry dropped", len(bundle.CACerts))
+	}
+
+	issuer.RequireCA = true
+	if _, err := issuer.Issue(context.Background()); err == nil {
+		t.Fatal("expected RequireCA to reject a response whose CA entries were all dropped")
+	}
+}
+
 func TestIssuerAcceptsValidCAPEM(t *testing.T) {
 	t.Parallel()
 
@@ -153,44 +243,970 @@ func TestIssuerAllowMissingCAWhenNotRequired(t *testing.T) {
 	}
 }
 
-func newTestCerts(t *testing.T) (caPEM, leafPEM, keyPEM []byte) {
-	t.Helper()
+func TestIssuerIssuerRefPath(t *testing.T) {
+	t.Parallel()
 
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	cases := []struct {
+		ref  string
+		want string
+	}{
+		{"", "/v1/pki/issue/role"},
+		{"next-ca", "/v1/pki/issuer/next-ca/issue/role"},
+	}
+	for _, c := range cases {
+		issuer := &Issuer{
+			Client:    &Client{Addr: server.URL, Token: "tok"},
+			PKIPath:   "pki",
+			Role:      "role",
+			IssuerRef: c.ref,
+		}
+		if _, err := issuer.Issue(context.Background()); err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		if gotPath != c.want {
+			t.Fatalf("IssuerRef %q: path = %q, want %q", c.ref, gotPath, c.want)
+		}
+	}
+}
+
+func TestIssuerNotAfterOverridesTTL(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var got IssueRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	notAfter := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	issuer := &Issuer{
+		Client:   &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:  "pki",
+		Role:     "role",
+		TTL:      time.Hour,
+		NotAfter: notAfter,
+	}
+	bundle, err := issuer.Issue(context.Background())
 	if err != nil {
-		t.Fatalf("generate key: %v", err)
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got.NotAfter != "2026-03-01T12:00:00Z" {
+		t.Fatalf("not_after = %q, want RFC3339 %s", got.NotAfter, notAfter)
+	}
+	if got.TTL != "" {
+		t.Fatalf("expected ttl to be omitted when NotAfter is set, got %q", got.TTL)
 	}
+	// Scheduling follows the issued certificate, not the requested expiry.
+	if bundle.NotAfter.Equal(notAfter) {
+		t.Fatal("expected bundle NotAfter to come from the issued certificate")
+	}
+}
 
-	now := time.Now()
-	caTmpl := &x509.Certificate{
-		SerialNumber:          big.NewInt(1),
-		Subject:               pkix.Name{CommonName: "Test CA"},
-		NotBefore:             now.Add(-time.Minute),
-		NotAfter:              now.Add(time.Hour),
-		IsCA:                  true,
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
-		BasicConstraintsValid: true,
+func TestIssuerDedupesSANs(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var got IssueRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:   &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:  "pki",
+		Role:     "role",
+		AltNames: []string{"a.svc", "b.svc", "a.svc"},
+		URISANs: []string{
+			"spiffe://corp/prod/svc",
+			"spiffe://corp/prod/svc",
+		},
 	}
-	leafTmpl := &x509.Certificate{
-		SerialNumber: big.NewInt(2),
-		Subject:      pkix.Name{CommonName: "Test Leaf"},
-		NotBefore:    now.Add(-time.Minute),
-		NotAfter:     now.Add(time.Hour),
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if len(got.AltNames) != 2 || got.AltNames[0] != "a.svc" || got.AltNames[1] != "b.svc" {
+		t.Fatalf("alt_names = %v, want [a.svc b.svc]", got.AltNames)
 	}
+	if len(got.URISANs) != 1 {
+		t.Fatalf("uri_sans = %v, want 1 entry", got.URISANs)
+	}
+}
 
-	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &key.PublicKey, key)
+func TestIssuerSubjectSerialNumber(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:              &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:             "pki",
+		Role:                "role",
+		SubjectSerialNumber: "device-0042",
+	}
+	bundle, err := issuer.Issue(context.Background())
 	if err != nil {
-		t.Fatalf("create CA cert: %v", err)
+		t.Fatalf("Issue failed: %v", err)
 	}
-	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, caTmpl, &key.PublicKey, key)
+	if got["serial_number"] != "device-0042" {
+		t.Fatalf("serial_number = %v, want device-0042", got["serial_number"])
+	}
+	if bundle.Cert.Leaf.SerialNumber.String() == "device-0042" {
+		t.Fatal("subject serial number must not replace the cert serial")
+	}
+}
+
+func TestIssuerNotBeforeDuration(t *testing.T) {
+	t.Parallel()
+
+	// A cert backdated well beyond its remaining validity, so scheduling off
+	// NotBefore would be easy to tell apart from scheduling off NotAfter.
+	now := time.Now()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		t.Fatalf("create leaf cert: %v", err)
+		t.Fatalf("generate key: %v", err)
 	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		NotBefore:    now.Add(-2 * time.Hour),
+		NotAfter:     now.Add(30 * time.Minute),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
 
-	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
-	leafPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
-	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
-	return caPEM, leafPEM, keyPEM
+	var mu sync.Mutex
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:            &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:           "pki",
+		Role:              "role",
+		NotBeforeDuration: 30 * time.Second,
+	}
+	mgr := certmanager.NewWithOptions(issuer, certmanager.Options{Now: func() time.Time { return now }})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mgr.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	var next time.Time
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var ok bool
+		if next, ok = mgr.NextRotation(); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Run did not schedule a rotation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	nbd := got["not_before_duration"]
+	mu.Unlock()
+	if nbd != "30s" {
+		t.Fatalf("not_before_duration = %v, want 30s", nbd)
+	}
+	// Two thirds of the 30m left until NotAfter, plus up to 10% jitter.
+	if wait := next.Sub(now); wait < 19*time.Minute || wait > 23*time.Minute {
+		t.Fatalf("next rotation in %s, want about 20m from NotAfter", wait)
+	}
+}
+
+func TestIssuerSignatureBits(t *testing.T) {
+	t.Parallel()
+
+	if got := issueBody(t, &Issuer{SignatureBits: 384}); got["signature_bits"] != float64(384) {
+		t.Fatalf("signature_bits = %v, want 384", got["signature_bits"])
+	}
+	if got := issueBody(t, &Issuer{}); got["signature_bits"] != nil {
+		t.Fatalf("signature_bits = %v, want it omitted to defer to the role", got["signature_bits"])
+	}
+}
+
+func TestIssuerNoStore(t *testing.T) {
+	t.Parallel()
+
+	for _, noStore := range []bool{true, false} {
+		got := issueBody(t, &Issuer{NoStore: noStore})
+		if v, ok := got["no_store"]; noStore && v != true || !noStore && ok {
+			t.Fatalf("NoStore %v: no_store = %v (sent %v)", noStore, v, ok)
+		}
+	}
+}
+
+func TestIssuerLegacyStringSANs(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:           &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:          "pki",
+		Role:             "role",
+		AltNames:         []string{"a.svc", "b.svc"},
+		IPSANs:           []string{"10.0.0.1"},
+		URISANs:          []string{"spiffe://corp/prod/svc"},
+		LegacyStringSANs: true,
+	}
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got["alt_names"] != "a.svc,b.svc" || got["ip_sans"] != "10.0.0.1" || got["uri_sans"] != "spiffe://corp/prod/svc" {
+		t.Fatalf("request = %v, want comma-joined SAN strings", got)
+	}
+}
+
+func TestIssuerHonorsRecoveryTTL(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var (
+		calls int
+		ttls  []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, `{"errors":["internal error"]}`, http.StatusInternalServerError)
+			return
+		}
+		var got IssueRequest
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		ttls = append(ttls, got.TTL)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:  &Client{Addr: server.URL, Token: "tok"},
+		PKIPath: "pki",
+		Role:    "role",
+		TTL:     6 * time.Hour,
+	}
+	mgr := certmanager.NewWithOptions(issuer, certmanager.Options{RecoveryTTL: 15 * time.Minute})
+	ctx := context.Background()
+	if err := mgr.Rotate(ctx); err == nil {
+		t.Fatal("expected first rotation to fail")
+	}
+	for range 2 {
+		if err := mgr.Rotate(ctx); err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+	}
+	if len(ttls) != 2 || ttls[0] != "15m0s" || ttls[1] != "6h0m0s" {
+		t.Fatalf("ttls = %v, want [15m0s 6h0m0s]", ttls)
+	}
+}
+
+func TestIssuerMaxTTL(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var got []IssueRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req IssueRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		got = append(got, req)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	var logs bytes.Buffer
+	issuer := &Issuer{
+		Client:  &Client{Addr: server.URL, Token: "tok"},
+		PKIPath: "pki",
+		Role:    "role",
+		TTL:     3 * 365 * 24 * time.Hour,
+		MaxTTL:  24 * time.Hour,
+		Logger:  slog.New(slog.NewTextHandler(&logs, nil)),
+	}
+	ctx := context.Background()
+	if _, err := issuer.Issue(ctx); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	issuer.TTL, issuer.NotAfter = 0, time.Now().Add(48*time.Hour)
+	if _, err := issuer.Issue(ctx); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	issuer.NotAfter = time.Time{}
+	issuer.RequestHook = func(_ context.Context, req *IssueRequest) error {
+		req.TTL = "3600"
+		return nil
+	}
+	if _, err := issuer.Issue(ctx); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d requests, want 3", len(got))
+	}
+	for i, want := range []string{"24h0m0s", "24h0m0s", "3600"} {
+		if got[i].TTL != want || got[i].NotAfter != "" {
+			t.Fatalf("request %d ttl=%q not_after=%q, want ttl %q", i, got[i].TTL, got[i].NotAfter, want)
+		}
+	}
+	if !strings.Contains(logs.String(), "MaxTTL") {
+		t.Fatalf("expected a clamp warning, got logs %q", logs.String())
+	}
+
+	issuer.RequestHook = nil
+	issuer.TTL, issuer.StrictMaxTTL = 48*time.Hour, true
+	if _, err := issuer.Issue(ctx); err == nil || !strings.Contains(err.Error(), "exceeds MaxTTL") {
+		t.Fatalf("Issue() = %v, want MaxTTL rejection", err)
+	}
+	if len(got) != 3 {
+		t.Fatal("expected a rejected request not to reach vault")
+	}
+}
+
+func TestIssuerMultiCertCAPEM(t *testing.T) {
+	t.Parallel()
+
+	rootPEM, interPEM, leafPEM, keyPEM := newTestChain(t)
+	chain := string(interPEM) + string(rootPEM)
+
+	for _, field := range []string{"issuing_ca", "ca_chain"} {
+		data := map[string]any{
+			"certificate": string(leafPEM),
+			"private_key": string(keyPEM),
+		}
+		if field == "ca_chain" {
+			data[field] = []string{chain}
+		} else {
+			data[field] = chain
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+		}))
+		t.Cleanup(server.Close)
+
+		issuer := &Issuer{
+			Client:  &Client{Addr: server.URL, Token: "tok"},
+			PKIPath: "pki",
+			Role:    "role",
+		}
+		bundle, err := issuer.Issue(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Issue failed: %v", field, err)
+		}
+		if len(bundle.CACerts) != 2 {
+			t.Fatalf("%s: CACerts = %d, want 2", field, len(bundle.CACerts))
+		}
+		if len(bundle.Cert.Certificate) != 2 {
+			t.Fatalf("%s: presented chain = %d certs, want leaf and intermediate", field, len(bundle.Cert.Certificate))
+		}
+		if err := certmanagertest.VerifyBundle(bundle); err != nil {
+			t.Fatalf("%s: VerifyBundle: %v", field, err)
+		}
+	}
+}
+
+func TestIssuerIncludeRoot(t *testing.T) {
+	t.Parallel()
+
+	rootPEM, interPEM, leafPEM, keyPEM := newTestChain(t)
+	for _, tc := range []struct {
+		name        string
+		certificate string
+		includeRoot bool
+		want        int
+	}{
+		{"default", string(leafPEM), false, 2},
+		{"root in certificate field", string(leafPEM) + string(interPEM) + string(rootPEM), false, 2},
+		{"include root", string(leafPEM), true, 3},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"certificate": tc.certificate,
+				"private_key": string(keyPEM),
+				"ca_chain":    []string{string(interPEM), string(rootPEM)},
+			}})
+		}))
+		t.Cleanup(server.Close)
+
+		issuer := &Issuer{
+			Client:      &Client{Addr: server.URL, Token: "tok"},
+			PKIPath:     "pki",
+			Role:        "role",
+			IncludeRoot: tc.includeRoot,
+		}
+		bundle, err := issuer.Issue(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Issue failed: %v", tc.name, err)
+		}
+		chain := bundle.Cert.Certificate
+		if len(chain) != tc.want {
+			t.Fatalf("%s: presented chain = %d certs, want %d", tc.name, len(chain), tc.want)
+		}
+		last, err := x509.ParseCertificate(chain[len(chain)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isRoot := last.Subject.CommonName == "Test Root"; isRoot != tc.includeRoot {
+			t.Fatalf("%s: chain ends with %q", tc.name, last.Subject.CommonName)
+		}
+		if len(bundle.CACerts) != 2 {
+			t.Fatalf("%s: CACerts = %d, want root and intermediate", tc.name, len(bundle.CACerts))
+		}
+	}
+}
+
+func TestIssuerRejectsMalformedURISAN(t *testing.T) {
+	t.Parallel()
+
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	for _, san := range []string{"::::", "corp/prod/svc", "spiffe://Corp/prod/svc", "spiffe://corp/prod/../svc"} {
+		issuer := &Issuer{
+			Client:  &Client{Addr: server.URL, Token: "tok"},
+			PKIPath: "pki",
+			Role:    "role",
+			URISANs: []string{san},
+		}
+		if _, err := issuer.Issue(context.Background()); err == nil {
+			t.Fatalf("expected uri SAN %q to be rejected", san)
+		}
+	}
+	if called {
+		t.Fatal("expected malformed uri SAN to be rejected before the HTTP call")
+	}
+}
+
+func TestIssuerEnforceURISANs(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:  &Client{Addr: server.URL, Token: "tok"},
+		PKIPath: "pki",
+		Role:    "role",
+		URISANs: []string{"spiffe://corp/prod/svc"},
+	}
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("expected dropped uri SANs to be allowed by default: %v", err)
+	}
+
+	issuer.EnforceURISANs = true
+	if _, err := issuer.Issue(context.Background()); err == nil {
+		t.Fatal("expected dropped uri SANs to be rejected when EnforceURISANs=true")
+	}
+}
+
+func TestIssuerRequiredSANs(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:       &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:      "pki",
+		Role:         "role",
+		URISANs:      []string{"spiffe://corp/prod/svc"},
+		RequiredSANs: []string{"spiffe://corp/prod/svc"},
+	}
+	if _, err := issuer.Issue(context.Background()); err == nil {
+		t.Fatal("expected a filtered required SAN to be rejected")
+	}
+
+	leaf := &x509.Certificate{
+		DNSNames:    []string{"svc", "svc.internal", "added.by.role"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:        []*url.URL{{Scheme: "spiffe", Host: "corp", Path: "/prod/svc"}},
+	}
+	if err := checkRequiredSANs(leaf, []string{"svc.internal", "10.0.0.1", "spiffe://corp/prod/svc"}); err != nil {
+		t.Fatalf("expected present SANs to pass: %v", err)
+	}
+	req := IssueRequest{
+		CommonName: "svc",
+		AltNames:   []string{"svc.internal", "dropped.by.role"},
+		IPSANs:     []string{"10.0.0.1"},
+		URISANs:    []string{"spiffe://corp/prod/svc"},
+	}
+	if extra := extraSANs(leaf, req); len(extra) != 1 || extra[0] != "added.by.role" {
+		t.Fatalf("extraSANs = %v, want [added.by.role]", extra)
+	}
+}
+
+func TestIssuerExpectedCAFingerprints(t *testing.T) {
+	t.Parallel()
+
+	caPEM, leafPEM, keyPEM := newTestCerts(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+				"issuing_ca":  string(caPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	block, _ := pem.Decode(caPEM)
+	if block == nil {
+		t.Fatal("failed to decode CA PEM")
+	}
+	match := sha256.Sum256(block.Bytes)
+	other := sha256.Sum256([]byte("some other CA"))
+
+	issuer := &Issuer{
+		Client:                 &Client{Addr: server.URL, Token: "tok"},
+		PKIPath:                "pki",
+		Role:                   "role",
+		ExpectedCAFingerprints: [][sha256.Size]byte{other, match},
+	}
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("expected matching CA pin to pass: %v", err)
+	}
+
+	issuer.ExpectedCAFingerprints = [][sha256.Size]byte{other}
+	if _, err := issuer.Issue(context.Background()); err == nil {
+		t.Fatal("expected non-matching CA pin to be rejected")
+	}
+}
+
+func TestIssuerExpectedCAFingerprintsPinsRoot(t *testing.T) {
+	t.Parallel()
+
+	rootPEM, interPEM, leafPEM, keyPEM := newTestChain(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+				"ca_chain":    []string{string(interPEM), string(rootPEM)},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	fingerprint := func(p []byte) [sha256.Size]byte {
+		block, _ := pem.Decode(p)
+		if block == nil {
+			t.Fatal("failed to decode CA PEM")
+		}
+		return sha256.Sum256(block.Bytes)
+	}
+	issuer := &Issuer{
+		Client:  &Client{Addr: server.URL, Token: "tok"},
+		PKIPath: "pki",
+		Role:    "role",
+	}
+	for name, pin := range map[string][sha256.Size]byte{"root": fingerprint(rootPEM), "intermediate": fingerprint(interPEM)} {
+		issuer.ExpectedCAFingerprints = [][sha256.Size]byte{pin}
+		if _, err := issuer.Issue(context.Background()); err != nil {
+			t.Fatalf("expected %s pin to pass: %v", name, err)
+		}
+	}
+	issuer.ExpectedCAFingerprints = [][sha256.Size]byte{sha256.Sum256([]byte("some other CA"))}
+	if _, err := issuer.Issue(context.Background()); err == nil {
+		t.Fatal("expected non-matching CA pin to be rejected")
+	}
+}
+
+func TestIssuerRequestHook(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var got IssueRequest
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{
+		Client:  &Client{Addr: server.URL, Token: "tok"},
+		PKIPath: "pki",
+		Role:    "role",
+		TTL:     time.Hour,
+		RequestHook: func(_ context.Context, req *IssueRequest) error {
+			req.TTL = "5m"
+			return nil
+		},
+	}
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got.TTL != "5m" {
+		t.Fatalf("ttl = %q, want hook-modified 5m", got.TTL)
+	}
+
+	hookErr := errors.New("overloaded")
+	issuer.RequestHook = func(context.Context, *IssueRequest) error { return hookErr }
+	if _, err := issuer.Issue(context.Background()); !errors.Is(err, hookErr) {
+		t.Fatalf("Issue() = %v, want hook error", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected hook error to prevent the request, got %d calls", calls)
+	}
+}
+
+func newTestCerts(t *testing.T) (caPEM, leafPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test Leaf"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, caTmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+
+	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	leafPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return caPEM, leafPEM, keyPEM
+}
+
+// newTestChain returns a root -> intermediate -> leaf chain and the leaf key.
+func newTestChain(t *testing.T) (rootPEM, interPEM, leafPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	now := time.Now()
+	ca := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             now.Add(-time.Minute),
+			NotAfter:              now.Add(time.Hour),
+			IsCA:                  true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+		}
+	}
+	rootTmpl, interTmpl := ca(1, "Test Root"), ca(2, "Test Intermediate")
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "Test Leaf"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create root cert: %v", err)
+	}
+	interDER, err := x509.CreateCertificate(rand.Reader, interTmpl, rootTmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create intermediate cert: %v", err)
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, interTmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+
+	encode := func(der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return encode(rootDER), encode(interDER), encode(leafDER), keyPEM
+}
+
+func TestIssuerUsesPrivateKeyType(t *testing.T) {
+	t.Parallel()
+
+	_, rsaLeaf, rsaKeyPEM := newTestCerts(t)
+	rsaBlock, _ := pem.Decode(rsaKeyPEM)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	ecDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &ecKey.PublicKey, ecKey)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	ecLeaf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ecDER})
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name, keyType string
+		leaf          []byte
+		key           *pem.Block
+		wantErr       bool
+	}{
+		{"rsa", "rsa", rsaLeaf, rsaBlock, false},
+		{"rsa relabeled", "rsa", rsaLeaf, &pem.Block{Type: "KEY", Bytes: rsaBlock.Bytes}, false},
+		{"ec", "ec", ecLeaf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}, false},
+		{"ec relabeled", "ec", ecLeaf, &pem.Block{Type: "KEY", Bytes: sec1}, false},
+		{"ec labeled as rsa", "rsa", ecLeaf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}, true},
+		{"relabeled without type", "", ecLeaf, &pem.Block{Type: "KEY", Bytes: sec1}, true},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			data := map[string]any{
+				"certificate": string(tc.leaf),
+				"private_key": string(pem.EncodeToMemory(tc.key)),
+			}
+			if tc.keyType != "" {
+				data["private_key_type"] = tc.keyType
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+		}))
+		t.Cleanup(server.Close)
+
+		issuer := &Issuer{Client: &Client{Addr: server.URL, Token: "tok"}, PKIPath: "pki", Role: "role"}
+		bundle, err := issuer.Issue(context.Background())
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%s: expected Issue to fail", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Issue failed: %v", tc.name, err)
+		}
+		if bundle.Cert.PrivateKey == nil || bundle.Cert.Leaf == nil {
+			t.Fatalf("%s: incomplete key pair", tc.name)
+		}
+	}
+}
+
+func TestIssuerRejectsMismatchedKey(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, _ := newTestCerts(t)
+	_, _, otherKeyPEM := newTestCerts(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"certificate":      string(leafPEM),
+			"private_key":      string(otherKeyPEM),
+			"private_key_type": "rsa",
+		}})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer := &Issuer{Client: &Client{Addr: server.URL, Token: "tok"}, PKIPath: "pki", Role: "role"}
+	if _, err := issuer.Issue(context.Background()); err == nil {
+		t.Fatal("expected a cert and key that do not belong together to be rejected")
+	}
+}
+
+func TestIssuerPlannedRequest(t *testing.T) {
+	t.Parallel()
+
+	issuer := &Issuer{
+		CommonName: "svc",
+		AltNames:   []string{"svc.local", "svc.local", "svc"},
+		URISANs:    []string{"spiffe://corp/prod/svc", "spiffe://corp/prod/svc"},
+		TTL:        48 * time.Hour,
+		MaxTTL:     24 * time.Hour,
+		RequestHook: func(_ context.Context, req *IssueRequest) error {
+			req.IPSANs = append(req.IPSANs, "10.0.0.1")
+			return nil
+		},
+	}
+	req, err := issuer.PlannedRequest(context.Background())
+	if err != nil {
+		t.Fatalf("PlannedRequest failed: %v", err)
+	}
+	if !slices.Equal(req.AltNames, []string{"svc.local", "svc"}) || !slices.Equal(req.URISANs, []string{"spiffe://corp/prod/svc"}) {
+		t.Fatalf("SANs not deduplicated: %+v", req)
+	}
+	if !slices.Equal(req.IPSANs, []string{"10.0.0.1"}) || req.TTL != "24h0m0s" {
+		t.Fatalf("expected the hook and MaxTTL to apply: %+v", req)
+	}
+
+	issuer.URISANs = []string{"spiffe://corp/prod/../svc"}
+	if _, err := issuer.PlannedRequest(context.Background()); err == nil {
+		t.Fatal("expected an invalid URI SAN to fail the plan")
+	}
+}
+
+// issueBody issues through issuer against a fake Vault and returns the
+// decoded request body. Client, PKIPath and Role are filled in.
+func issueBody(t *testing.T, issuer *Issuer) map[string]any {
+	t.Helper()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{
+				"certificate": string(leafPEM),
+				"private_key": string(keyPEM),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	issuer.Client = &Client{Addr: server.URL, Token: "tok"}
+	issuer.PKIPath, issuer.Role = "pki", "role"
+	if _, err := issuer.Issue(context.Background()); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	return got
 }
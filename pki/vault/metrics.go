@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// ClientMetrics receives authentication counters from a Client, e.g. to
+// tell rotation failures caused by auth churn from issuer outages. Methods
+// are called synchronously from the request path and must not block.
+type ClientMetrics interface {
+	// LoginSucceeded is called after every successful AppRole login,
+	// including relogins.
+	LoginSucceeded()
+	// LoginFailed is called when an AppRole login fails, with the cause.
+	LoginFailed(err error)
+	// TokenRefreshed is called when a login replaces a token that was
+	// still in use, i.e. a proactive ReloginBefore refresh. The client
+	// re-logs in rather than renewing tokens, so this is its renewal.
+	TokenRefreshed()
+}
+
+// PrometheusMetrics is a ClientMetrics that counts events and serves them in
+// the Prometheus text exposition format, without depending on a client
+// library. Mount it as an http.Handler, or call WriteTo from an existing
+// metrics endpoint. The zero value is ready to use.
+type PrometheusMetrics struct {
+	logins        atomic.Uint64
+	loginFailures atomic.Uint64
+	refreshes     atomic.Uint64
+}
+
+func (p *PrometheusMetrics) LoginSucceeded()   { p.logins.Add(1) }
+func (p *PrometheusMetrics) LoginFailed(error) { p.loginFailures.Add(1) }
+func (p *PrometheusMetrics) TokenRefreshed()   { p.refreshes.Add(1) }
+
+// WriteTo writes the counters in the Prometheus text exposition format.
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, `# HELP vault_logins_total Successful Vault AppRole logins.
+# TYPE vault_logins_total counter
+vault_logins_total %d
+# HELP vault_login_failures_total Failed Vault AppRole logins.
+# TYPE vault_login_failures_total counter
+vault_login_failures_total %d
+# HELP vault_token_refreshes_total Vault tokens replaced before expiry.
+# TYPE vault_token_refreshes_total counter
+vault_token_refreshes_total %d
+`, p.logins.Load(), p.loginFailures.Load(), p.refreshes.Load())
+	return int64(n), err
+}
+
+// ServeHTTP serves the counters, e.g. on /metrics.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
@@ -1,20 +1,205 @@
 package vault
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
 func parseNotAfter(certPEM []byte) (time.Time, error) {
+	cert, err := parseLeafPEM(certPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// parseLeafPEM parses the first PEM block of a certificate field, naming the
+// block type found when it is not a certificate (e.g. a key in the wrong field).
+func parseLeafPEM(certPEM []byte) (*x509.Certificate, error) {
 	block, _ := pem.Decode(certPEM)
 	if block == nil {
-		return time.Time{}, errors.New("invalid cert PEM")
+		return nil, errors.New("vault certificate field contains no PEM block")
+	}
+	if block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("vault certificate field holds a %q PEM block, want CERTIFICATE; check that the response fields are not swapped", block.Type)
 	}
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return time.Time{}, err
+		return nil, fmt.Errorf("parse vault certificate: %w", err)
 	}
-	return cert.NotAfter, nil
+	return cert, nil
+}
+
+// parseCertsPEM returns every parseable CERTIFICATE block in data, skipping
+// anything else, mirroring x509.CertPool.AppendCertsFromPEM.
+func parseCertsPEM(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+// parseKeyPair builds a tls.Certificate from certPEM and the private key block
+// in keyPEM that matches it. Non-key blocks (e.g. EC PARAMETERS) are ignored,
+// and when several key blocks are present the first matching one is used.
+//
+// keyType is Vault's private_key_type ("rsa", "ec" or "ed25519"). When set,
+// keys are parsed with the encodings Vault uses for that type, whatever
+// their PEM label, so blocks relabeled by a proxy (e.g. "KEY") still load.
+// Empty infers the type from the block, as tls.X509KeyPair does.
+func parseKeyPair(certPEM, keyPEM []byte, keyType string) (tls.Certificate, error) {
+	var firstErr error
+	for rest := keyPEM; len(rest) > 0; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if keyType == "" && block.Type != "PRIVATE KEY" && !strings.HasSuffix(block.Type, " PRIVATE KEY") {
+			continue
+		}
+		if keyType != "" && (block.Type == "CERTIFICATE" || strings.HasSuffix(block.Type, " PARAMETERS")) {
+			continue
+		}
+		keyBlock := block
+		if keyType != "" {
+			der, err := parseTypedKey(block.Bytes, keyType)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			keyBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+		cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(keyBlock))
+		if err == nil {
+			return cert, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return tls.Certificate{}, firstErr
+	}
+	return tls.Certificate{}, errors.New("vault private_key contained no private key PEM block")
+}
+
+// checkKeyMatch reports an error unless leaf's public key is key's, by
+// comparing their PKIX encodings. It guards every issuance path, including
+// CSR signing and responses reassembled from relabeled PEM, against a cert
+// and key that do not belong together.
+func checkKeyMatch(leaf *x509.Certificate, key crypto.PrivateKey) error {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("vault private key of type %T cannot be checked against the cert", key)
+	}
+	want, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil {
+		return fmt.Errorf("marshal vault cert public key: %w", err)
+	}
+	got, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return fmt.Errorf("marshal vault private key's public key: %w", err)
+	}
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("vault issued cert (serial %s) does not match the private key", leaf.SerialNumber)
+	}
+	return nil
+}
+
+// parseTypedKey parses der as a private key of Vault's private_key_type and
+// returns it re-encoded as PKCS#8.
+func parseTypedKey(der []byte, keyType string) ([]byte, error) {
+	var (
+		key any
+		err error
+	)
+	switch keyType {
+	case "rsa":
+		if key, err = x509.ParsePKCS1PrivateKey(der); err != nil {
+			key, err = x509.ParsePKCS8PrivateKey(der)
+		}
+		if _, ok := key.(*rsa.PrivateKey); err == nil && !ok {
+			err = fmt.Errorf("key is %T", key)
+		}
+	case "ec":
+		if key, err = x509.ParseECPrivateKey(der); err != nil {
+			key, err = x509.ParsePKCS8PrivateKey(der)
+		}
+		if _, ok := key.(*ecdsa.PrivateKey); err == nil && !ok {
+			err = fmt.Errorf("key is %T", key)
+		}
+	case "ed25519":
+		key, err = x509.ParsePKCS8PrivateKey(der)
+		if _, ok := key.(ed25519.PrivateKey); err == nil && !ok {
+			err = fmt.Errorf("key is %T", key)
+		}
+	default:
+		return nil, fmt.Errorf("vault private_key_type %q is not supported", keyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse vault %s private key: %w", keyType, err)
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// appendIntermediates appends the certs in caCerts to the presented chain,
+// skipping any already present, so peers that trust only the root can build a
+// path to the leaf. Unless includeRoot, self-signed roots are left out, and
+// dropped if the certificate field already carried them: peers must trust the
+// root anyway, so presenting it only adds bytes to every handshake.
+func appendIntermediates(cert *tls.Certificate, caCerts []*x509.Certificate, includeRoot bool) {
+	if !includeRoot && len(cert.Certificate) > 1 {
+		chain := cert.Certificate[:1]
+		for _, der := range cert.Certificate[1:] {
+			if c, err := x509.ParseCertificate(der); err == nil && selfSigned(c) {
+				continue
+			}
+			chain = append(chain, der)
+		}
+		cert.Certificate = chain
+	}
+	seen := make(map[string]struct{}, len(cert.Certificate))
+	for _, der := range cert.Certificate {
+		seen[string(der)] = struct{}{}
+	}
+	for _, ca := range caCerts {
+		if !includeRoot && selfSigned(ca) {
+			continue
+		}
+		if _, ok := seen[string(ca.Raw)]; ok {
+			continue
+		}
+		seen[string(ca.Raw)] = struct{}{}
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+}
+
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
 }
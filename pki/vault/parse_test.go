@@ -1,11 +1,15 @@
 package vault
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 )
@@ -40,3 +44,108 @@ func TestParseNotAfter(t *testing.T) {
 		t.Fatalf("parseNotAfter = %s, want %s", got, expiry)
 	}
 }
+
+func TestParseNotAfterReportsBlockType(t *testing.T) {
+	t.Parallel()
+
+	_, _, keyPEM := newTestCerts(t)
+	cases := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"empty", nil, "no PEM block"},
+		{"not PEM", []byte("certificate"), "no PEM block"},
+		{"swapped key", keyPEM, `"RSA PRIVATE KEY" PEM block, want CERTIFICATE`},
+		{"garbage cert", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0x30, 0x00}}), "parse vault certificate"},
+	}
+	for _, c := range cases {
+		_, err := parseNotAfter(c.in)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: parseNotAfter err = %v, want it to mention %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestParseKeyPairSkipsNonMatchingBlocks(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	stale, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	ecKeyPEM := func(k *ecdsa.PrivateKey) []byte {
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			t.Fatalf("marshal key: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+	}
+	params, err := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})
+	if err != nil {
+		t.Fatalf("marshal params: %v", err)
+	}
+	paramsPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: params})
+
+	cases := map[string][]byte{
+		"params then key":     append(append([]byte{}, paramsPEM...), ecKeyPEM(key)...),
+		"stale key then key":  append(append([]byte{}, ecKeyPEM(stale)...), ecKeyPEM(key)...),
+		"params, stale, good": append(append(append([]byte{}, paramsPEM...), ecKeyPEM(stale)...), ecKeyPEM(key)...),
+	}
+	for name, keyPEM := range cases {
+		if _, err := parseKeyPair(certPEM, keyPEM, ""); err != nil {
+			t.Fatalf("%s: parseKeyPair failed: %v", name, err)
+		}
+	}
+
+	if _, err := parseKeyPair(certPEM, paramsPEM, ""); err == nil {
+		t.Fatal("expected error when private_key has no key block")
+	}
+	if _, err := parseKeyPair(certPEM, ecKeyPEM(stale), ""); err == nil {
+		t.Fatal("expected error when no key block matches the cert")
+	}
+}
+
+func TestCheckKeyMatch(t *testing.T) {
+	t.Parallel()
+
+	_, leafPEM, keyPEM := newTestCerts(t)
+	leaf, err := parseLeafPEM(leafPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkKeyMatch(leaf, key); err != nil {
+		t.Fatalf("checkKeyMatch failed for a matching pair: %v", err)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkKeyMatch(leaf, other); err == nil || !strings.Contains(err.Error(), "does not match the private key") {
+		t.Fatalf("checkKeyMatch() = %v, want a mismatch error", err)
+	}
+	if err := checkKeyMatch(leaf, "not a key"); err == nil {
+		t.Fatal("expected a non-signer key to be rejected")
+	}
+}
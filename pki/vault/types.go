@@ -3,24 +3,114 @@ package vault
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// maxSnippet bounds how much of an undecodable body is echoed in errors.
+const maxSnippet = 256
+
 type IssueRequest struct {
 	CommonName string   `json:"common_name,omitempty"`
 	AltNames   []string `json:"alt_names,omitempty"`
+	IPSANs     []string `json:"ip_sans,omitempty"`
 	URISANs    []string `json:"uri_sans,omitempty"`
 	TTL        string   `json:"ttl,omitempty"`
+	NotAfter   string   `json:"not_after,omitempty"`
+	// NotBeforeDuration backdates the cert's NotBefore, e.g. "30s".
+	NotBeforeDuration string `json:"not_before_duration,omitempty"`
+	// SerialNumber is the subject serialNumber attribute, not the cert serial.
+	SerialNumber string `json:"serial_number,omitempty"`
+	// SignatureBits selects the hash Vault signs with (256, 384 or 512).
+	SignatureBits int `json:"signature_bits,omitempty"`
+	// NoStore asks Vault not to store the issued cert.
+	NoStore bool `json:"no_store,omitempty"`
+	// LegacyStringSANs sends alt_names, ip_sans and uri_sans as
+	// comma-separated strings for Vault versions that reject JSON arrays.
+	LegacyStringSANs bool `json:"-"`
+	// WrapTTL, if positive, is sent as X-Vault-Wrap-TTL so Vault returns a
+	// response-wrapping token, which the client unwraps to get the response.
+	WrapTTL time.Duration `json:"-"`
+}
+
+// MarshalJSON encodes the request in Vault's wire form.
+func (r IssueRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.wire())
+}
+
+// SignRequest is an IssueRequest carrying a PEM-encoded CSR for pki/sign.
+type SignRequest struct {
+	IssueRequest
+	CSR string `json:"csr"`
+}
+
+// MarshalJSON encodes the request in Vault's wire form.
+func (r SignRequest) MarshalJSON() ([]byte, error) {
+	w := r.IssueRequest.wire()
+	w.CSR = r.CSR
+	return json.Marshal(w)
+}
+
+// issueRequestJSON is the wire form of IssueRequest and SignRequest. SAN
+// lists hold either a []string or, for LegacyStringSANs, a joined string.
+type issueRequestJSON struct {
+	CommonName string `json:"common_name,omitempty"`
+	AltNames   any    `json:"alt_names,omitempty"`
+	IPSANs     any    `json:"ip_sans,omitempty"`
+	URISANs    any    `json:"uri_sans,omitempty"`
+	TTL        string `json:"ttl,omitempty"`
+	NotAfter   string `json:"not_after,omitempty"`
+	CSR        string `json:"csr,omitempty"`
+
+	NotBeforeDuration string `json:"not_before_duration,omitempty"`
+	SerialNumber      string `json:"serial_number,omitempty"`
+	SignatureBits     int    `json:"signature_bits,omitempty"`
+	NoStore           bool   `json:"no_store,omitempty"`
+}
+
+func (r IssueRequest) wire() issueRequestJSON {
+	sans := func(values []string) any {
+		if len(values) == 0 {
+			return nil
+		}
+		if r.LegacyStringSANs {
+			return strings.Join(values, ",")
+		}
+		return values
+	}
+	return issueRequestJSON{
+		CommonName: r.CommonName,
+		AltNames:   sans(r.AltNames),
+		IPSANs:     sans(r.IPSANs),
+		URISANs:    sans(r.URISANs),
+		TTL:        r.TTL,
+		NotAfter:   r.NotAfter,
+
+		NotBeforeDuration: r.NotBeforeDuration,
+		SerialNumber:      r.SerialNumber,
+		SignatureBits:     r.SignatureBits,
+		NoStore:           r.NoStore,
+	}
 }
 
 type IssueResponse struct {
 	Certificate string
 	PrivateKey  string
-	CAChain     []string
-	IssuingCA   string
+	// PrivateKeyType is Vault's private_key_type ("rsa", "ec" or "ed25519"),
+	// empty for sign responses and older versions that omit it.
+	PrivateKeyType string
+	CAChain        []string
+	IssuingCA      string
+
+	raw map[string]any // full decoded body, for Client.IssueRaw
 }
 
-func decodeIssue(respBody *http.Response) (*IssueResponse, error) {
+// decodeIssue decodes an issue or sign response. requireKey is false for
+// sign responses, which carry no private key.
+func decodeIssue(respBody *http.Response, requireKey bool) (*IssueResponse, error) {
 	defer func() {
 		_ = respBody.Body.Close()
 	}()
@@ -29,20 +119,44 @@ func decodeIssue(respBody *http.Response) (*IssueResponse, error) {
 		Data struct {
 			Certificate string   `json:"certificate"`
 			PrivateKey  string   `json:"private_key"`
+			KeyType     string   `json:"private_key_type"`
 			IssuingCA   string   `json:"issuing_ca"`
 			CAChain     []string `json:"ca_chain"`
 		} `json:"data"`
 	}
-	if err := json.NewDecoder(respBody.Body).Decode(&out); err != nil {
-		return nil, err
+	body, err := io.ReadAll(respBody.Body)
+	if err != nil {
+		return nil, fmt.Errorf("vault issue response (http %d): read body: %w", respBody.StatusCode, err)
 	}
-	if out.Data.Certificate == "" || out.Data.PrivateKey == "" {
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, &DecodeError{
+			StatusCode:  respBody.StatusCode,
+			ContentType: respBody.Header.Get("Content-Type"),
+			Body:        snippet(body),
+			Err:         err,
+		}
+	}
+	var raw map[string]any
+	_ = json.Unmarshal(body, &raw)
+	if out.Data.Certificate == "" {
+		return nil, errors.New("vault issue response missing certificate")
+	}
+	if requireKey && out.Data.PrivateKey == "" {
 		return nil, errors.New("vault issue response missing certificate/private_key")
 	}
 	return &IssueResponse{
-		Certificate: out.Data.Certificate,
-		PrivateKey:  out.Data.PrivateKey,
-		IssuingCA:   out.Data.IssuingCA,
-		CAChain:     out.Data.CAChain,
+		Certificate:    out.Data.Certificate,
+		PrivateKey:     out.Data.PrivateKey,
+		PrivateKeyType: out.Data.KeyType,
+		IssuingCA:      out.Data.IssuingCA,
+		CAChain:        out.Data.CAChain,
+		raw:            raw,
 	}, nil
 }
+
+func snippet(b []byte) string {
+	if len(b) > maxSnippet {
+		return string(b[:maxSnippet]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecodeIssueErrorContext(t *testing.T) {
+	t.Parallel()
+
+	html := "<html><body><h1>502 Bad Gateway</h1>" + strings.Repeat("x", 1024) + "</body></html>"
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       io.NopCloser(strings.NewReader(html)),
+	}
+
+	_, err := decodeIssue(resp, true)
+	if err == nil {
+		t.Fatal("expected decode error")
+	}
+	msg := err.Error()
+	for _, want := range []string{"http 200", "text/html", "502 Bad Gateway"} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("error %q missing %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "</html>") {
+		t.Fatalf("expected body snippet to be truncated: %q", msg)
+	}
+}
+
+func TestIssueRequestSANEncoding(t *testing.T) {
+	t.Parallel()
+
+	req := IssueRequest{
+		CommonName: "svc",
+		AltNames:   []string{"a.svc", "b.svc"},
+		IPSANs:     []string{"10.0.0.1", "10.0.0.2"},
+		URISANs:    []string{"spiffe://corp/prod/svc"},
+	}
+
+	arrays, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `{"common_name":"svc","alt_names":["a.svc","b.svc"],"ip_sans":["10.0.0.1","10.0.0.2"],"uri_sans":["spiffe://corp/prod/svc"]}`
+	if string(arrays) != want {
+		t.Fatalf("array form = %s, want %s", arrays, want)
+	}
+
+	req.LegacyStringSANs = true
+	legacy, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want = `{"common_name":"svc","alt_names":"a.svc,b.svc","ip_sans":"10.0.0.1,10.0.0.2","uri_sans":"spiffe://corp/prod/svc"}`
+	if string(legacy) != want {
+		t.Fatalf("legacy form = %s, want %s", legacy, want)
+	}
+
+	signed, err := json.Marshal(SignRequest{IssueRequest: req, CSR: "pem"})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want = `{"common_name":"svc","alt_names":"a.svc,b.svc","ip_sans":"10.0.0.1,10.0.0.2","uri_sans":"spiffe://corp/prod/svc","csr":"pem"}`
+	if string(signed) != want {
+		t.Fatalf("sign form = %s, want %s", signed, want)
+	}
+}
@@ -0,0 +1,265 @@
+// Package vaulttest provides a fake Vault/OpenBao PKI server for testing
+// code that issues certs through the vault package.
+package vaulttest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmmoran/spiffe-rotate/pki/vault"
+)
+
+// FlakyOptions configures a FlakyServer. All knobs except CAValidity can be
+// changed later with the FlakyServer setters.
+type FlakyOptions struct {
+	// Latency delays every response.
+	Latency time.Duration
+	// ErrorRate is the probability in [0, 1] that an issue request fails
+	// with HTTP 500.
+	ErrorRate float64
+	// TTL caps the lifetime of issued leaves, like a role's max_ttl. Set it
+	// short to simulate certs that are about to expire. Default: 1h.
+	TTL time.Duration
+	// CAValidity is the lifetime of the CA. Set it short to trigger
+	// certmanager.Options.OnCAExpiryWarning. Default: 24h.
+	CAValidity time.Duration
+}
+
+// FlakyServer is an httptest server that answers pki/issue/<role> requests
+// with real certs from an in-memory CA, subject to injected latency and
+// failures. Any mount path and role are accepted and no token is checked.
+type FlakyServer struct {
+	// URL is the base URL of the server, e.g. for vault.Client.Addr.
+	URL string
+
+	srv   *httptest.Server
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	caPEM string
+
+	mu       sync.Mutex
+	opts     FlakyOptions
+	failNext int
+	serial   int64
+	issued   int
+	failed   int
+}
+
+// NewFlakyServer starts a FlakyServer. Callers must Close it. Like
+// httptest.NewServer, it panics if the server cannot be set up.
+func NewFlakyServer(opts FlakyOptions) *FlakyServer {
+	if opts.TTL <= 0 {
+		opts.TTL = time.Hour
+	}
+	if opts.CAValidity <= 0 {
+		opts.CAValidity = 24 * time.Hour
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("vaulttest: generate CA key: " + err.Error())
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vaulttest CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(opts.CAValidity),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic("vaulttest: create CA cert: " + err.Error())
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic("vaulttest: parse CA cert: " + err.Error())
+	}
+
+	s := &FlakyServer{
+		ca:     ca,
+		caKey:  key,
+		caPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		opts:   opts,
+		serial: 1,
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *FlakyServer) Close() {
+	s.srv.Close()
+}
+
+// Client returns a vault.Client for the server.
+func (s *FlakyServer) Client() *vault.Client {
+	return &vault.Client{Addr: s.URL, Token: "vaulttest"}
+}
+
+// CA returns the CA cert that signs issued leaves.
+func (s *FlakyServer) CA() *x509.Certificate {
+	return s.ca
+}
+
+// SetLatency changes the delay applied to every response.
+func (s *FlakyServer) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.opts.Latency = d
+	s.mu.Unlock()
+}
+
+// SetErrorRate changes the probability that an issue request fails.
+func (s *FlakyServer) SetErrorRate(p float64) {
+	s.mu.Lock()
+	s.opts.ErrorRate = p
+	s.mu.Unlock()
+}
+
+// SetTTL changes the maximum lifetime of issued leaves.
+func (s *FlakyServer) SetTTL(d time.Duration) {
+	s.mu.Lock()
+	s.opts.TTL = d
+	s.mu.Unlock()
+}
+
+// FailNext makes the next n issue requests fail regardless of ErrorRate,
+// for deterministic backoff and recovery tests.
+func (s *FlakyServer) FailNext(n int) {
+	s.mu.Lock()
+	s.failNext = n
+	s.mu.Unlock()
+}
+
+// Issued returns the number of certs issued so far.
+func (s *FlakyServer) Issued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// Failed returns the number of issue requests failed so far.
+func (s *FlakyServer) Failed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+func (s *FlakyServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency := s.opts.Latency
+	s.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case r.URL.Path == "/v1/sys/health":
+		writeJSON(w, http.StatusOK, map[string]any{"initialized": true, "sealed": false, "standby": false})
+	case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/issue/"):
+		s.issue(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]any{"errors": []string{}})
+	}
+}
+
+func (s *FlakyServer) issue(w http.ResponseWriter, r *http.Request) {
+	var req vault.IssueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []string{"invalid request: " + err.Error()}})
+		return
+	}
+
+	s.mu.Lock()
+	fail := s.failNext > 0 || (s.opts.ErrorRate > 0 && mrand.Float64() < s.opts.ErrorRate)
+	if s.failNext > 0 {
+		s.failNext--
+	}
+	if fail {
+		s.failed++
+		s.mu.Unlock()
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"errors": []string{"vaulttest: injected failure"}})
+		return
+	}
+	s.serial++
+	serial := s.serial
+	ttl := s.opts.TTL
+	s.mu.Unlock()
+
+	if d, err := time.ParseDuration(req.TTL); err == nil && d > 0 && d < ttl {
+		ttl = d
+	}
+	resp, err := s.sign(serial, req, ttl)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"errors": []string{err.Error()}})
+		return
+	}
+
+	s.mu.Lock()
+	s.issued++
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"data": resp})
+}
+
+func (s *FlakyServer) sign(serial int64, req vault.IssueRequest, ttl time.Duration) (map[string]any, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: req.CommonName, SerialNumber: req.SerialNumber},
+		DNSNames:     req.AltNames,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, raw := range req.URISANs {
+		if u, err := url.Parse(raw); err == nil {
+			tmpl.URIs = append(tmpl.URIs, u)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.ca, &key.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"certificate":   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"private_key":   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		"issuing_ca":    s.caPEM,
+		"ca_chain":      []string{s.caPEM},
+		"serial_number": big.NewInt(serial).Text(16),
+		"expiration":    tmpl.NotAfter.Unix(),
+	}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
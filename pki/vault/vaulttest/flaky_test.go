@@ -0,0 +1,109 @@
+package vaulttest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager"
+	"github.com/cmmoran/spiffe-rotate/pki/certmanager/certmanagertest"
+	"github.com/cmmoran/spiffe-rotate/pki/vault"
+)
+
+func TestFlakyServerManagerRecovers(t *testing.T) {
+	t.Parallel()
+
+	srv := NewFlakyServer(FlakyOptions{})
+	t.Cleanup(srv.Close)
+	issuer := &vault.Issuer{
+		Client:  srv.Client(),
+		PKIPath: "pki",
+		Role:    "role",
+		URISANs: []string{"spiffe://corp/prod/svc"},
+		TTL:     10 * time.Minute,
+	}
+	mgr := certmanager.NewWithOptions(issuer, certmanager.Options{HistorySize: 4})
+
+	srv.FailNext(2)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := mgr.Rotate(ctx); err == nil {
+			t.Fatalf("rotation %d: expected injected failure", i)
+		}
+	}
+	b, err := mgr.RotateAndGet(ctx)
+	if err != nil {
+		t.Fatalf("expected recovery after injected failures: %v", err)
+	}
+	if err := certmanagertest.VerifyBundle(b); err != nil {
+		t.Fatalf("VerifyBundle: %v", err)
+	}
+	if got := b.NotAfter.Sub(time.Now()); got > 10*time.Minute {
+		t.Fatalf("leaf lifetime %s exceeds requested TTL", got)
+	}
+	if srv.Failed() != 2 || srv.Issued() != 1 {
+		t.Fatalf("failed = %d, issued = %d; want 2 and 1", srv.Failed(), srv.Issued())
+	}
+	if h := mgr.History(); len(h) != 3 || h[2].Outcome != certmanager.RotationRotated {
+		t.Fatalf("history = %+v, want two failures then a rotation", h)
+	}
+}
+
+func TestFlakyServerErrorRateAndLatency(t *testing.T) {
+	t.Parallel()
+
+	srv := NewFlakyServer(FlakyOptions{ErrorRate: 1})
+	t.Cleanup(srv.Close)
+	client := srv.Client()
+	if _, err := client.Issue(context.Background(), "pki", "role", vault.IssueRequest{}); err == nil {
+		t.Fatal("expected ErrorRate 1 to fail every request")
+	}
+
+	srv.SetErrorRate(0)
+	srv.SetLatency(200 * time.Millisecond)
+	impatient := srv.Client()
+	impatient.Timeout = 20 * time.Millisecond
+	if _, err := impatient.Issue(context.Background(), "pki", "role", vault.IssueRequest{}); err == nil {
+		t.Fatal("expected latency beyond the client timeout to fail")
+	}
+
+	srv.SetLatency(0)
+	srv.SetTTL(5 * time.Second)
+	issuer := &vault.Issuer{Client: client, PKIPath: "pki", Role: "role", TTL: time.Hour}
+	b, err := issuer.Issue(context.Background())
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got := time.Until(b.NotAfter); got > 5*time.Second {
+		t.Fatalf("leaf lifetime %s, want capped by SetTTL", got)
+	}
+}
+
+func TestFlakyServerTriggersCAExpiryWarning(t *testing.T) {
+	t.Parallel()
+
+	srv := NewFlakyServer(FlakyOptions{CAValidity: time.Minute})
+	t.Cleanup(srv.Close)
+	warned := make(chan []certmanager.CAExpiry, 1)
+	mgr := certmanager.NewWithOptions(&vault.Issuer{Client: srv.Client(), PKIPath: "pki", Role: "role"}, certmanager.Options{
+		OnCAExpiryWarning: func(_ context.Context, expiring []certmanager.CAExpiry) {
+			select {
+			case warned <- expiring:
+			default:
+			}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go mgr.Run(ctx)
+
+	select {
+	case expiring := <-warned:
+		if len(expiring) != 1 || expiring[0].Subject != "CN=vaulttest CA" {
+			t.Fatalf("expiring = %+v, want the vaulttest CA", expiring)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnCAExpiryWarning")
+	}
+}